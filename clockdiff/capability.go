@@ -0,0 +1,18 @@
+package clockdiff
+
+import (
+	"fmt"
+
+	"golang.org/x/net/icmp"
+)
+
+// CheckRawSocket reports whether a raw ICMP socket can be opened. If not, the
+// returned error wraps ErrPermission and says how to get the privilege on the
+// current platform.
+func CheckRawSocket() error {
+	c, err := icmp.ListenPacket("ip4:icmp", defaultAddress())
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrPermission, rawSocketHint, err)
+	}
+	return c.Close()
+}
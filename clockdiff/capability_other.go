@@ -0,0 +1,7 @@
+//go:build !windows
+
+package clockdiff
+
+const rawSocketHint = "raw ICMP sockets require root or CAP_NET_RAW"
+
+func defaultAddress() string { return "0.0.0.0" }
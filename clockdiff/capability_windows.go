@@ -0,0 +1,29 @@
+package clockdiff
+
+import "net"
+
+const rawSocketHint = "raw ICMP sockets require running as Administrator"
+
+// defaultAddress returns the first IPv4 address of an interface that is up.
+// Windows raw sockets bound to 0.0.0.0 do not receive ICMP replies.
+func defaultAddress() string {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return "0.0.0.0"
+	}
+	for _, ifi := range ifs {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				return ipnet.IP.String()
+			}
+		}
+	}
+	return "0.0.0.0"
+}
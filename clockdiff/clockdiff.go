@@ -0,0 +1,801 @@
+// Package clockdiff measures the clock difference between the local host and
+// a remote host using ICMP timestamp messages, like iputils' clockdiff.
+package clockdiff
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// protocolICMP is the IANA protocol number of ICMP for IPv4.
+const protocolICMP = 1
+
+// Family is the address family preference used when resolving the
+// destination.
+type Family int
+
+// Address family preferences. FamilyAny prefers IPv4.
+const (
+	FamilyAny Family = iota
+	FamilyInet
+	FamilyInet6
+)
+
+// ParseFamily returns the Family named by s, which is "any", "inet" or
+// "inet6".
+func ParseFamily(s string) (Family, error) {
+	switch s {
+	case "any":
+		return FamilyAny, nil
+	case "inet":
+		return FamilyInet, nil
+	case "inet6":
+		return FamilyInet6, nil
+	}
+	return FamilyAny, fmt.Errorf("unknown address family %q: must be any, inet or inet6", s)
+}
+
+// ErrNoTimestampOverIPv6 is returned when the destination is only reachable
+// over IPv6. ICMPv6 has no timestamp message, so the clock difference cannot
+// be measured.
+var ErrNoTimestampOverIPv6 = errors.New("ICMPv6 has no timestamp message; destination has no usable IPv4 address")
+
+// Errors returned by ClockDiff, possibly wrapping the underlying error. Use
+// errors.Is to test for them.
+var (
+	ErrResolve         = errors.New("cannot resolve destination")
+	ErrTimeout         = errors.New("timed out waiting for timestamp reply")
+	ErrPermission      = errors.New("permission denied opening ICMP socket")
+	ErrUnexpectedReply = errors.New("unexpected ICMP reply")
+
+	// ErrShortWrite is returned when the request could only be partly
+	// sent. Like ErrTimeout, it is retried up to Options.Retries times.
+	ErrShortWrite = errors.New("short write")
+
+	// ErrDatagramUnsupported is returned when a datagram ICMP socket
+	// (Network "udp4") refuses to send the timestamp request. Linux
+	// ones accept echo requests only; macOS ones accept timestamps.
+	ErrDatagramUnsupported = errors.New("datagram ICMP sockets of this platform do not send timestamp requests; use a raw socket")
+
+	ErrDestinationUnreachable = errors.New("destination unreachable")
+	ErrTimeExceeded           = errors.New("time exceeded")
+
+	// ErrNoSuchHost and ErrNoAddress are returned together with
+	// ErrResolve when the name does not exist (NXDOMAIN), or exists but
+	// has no address record of the wanted family. net.Resolver reports
+	// both alike, so they are told apart from the DNS responses to a
+	// second lookup. Where these cannot be seen, e.g. with the system
+	// resolver of Windows, neither is returned, only ErrResolve with the
+	// error of the resolver.
+	ErrNoSuchHost = errors.New("no such host")
+	ErrNoAddress  = errors.New("no address record")
+)
+
+var unreachableCodes = map[int]string{
+	0:  "network unreachable",
+	1:  "host unreachable",
+	2:  "protocol unreachable",
+	3:  "port unreachable",
+	4:  "fragmentation needed",
+	5:  "source route failed",
+	9:  "network administratively prohibited",
+	10: "host administratively prohibited",
+	13: "communication administratively prohibited",
+}
+
+// Options configures a measurement. The zero value probes over a raw
+// ip4:icmp socket bound to 0.0.0.0 (the first interface address on Windows,
+// where raw sockets need one) and waits 3 seconds for the reply.
+// Setting Network to "udp4" uses an unprivileged datagram ICMP socket.
+type Options struct {
+	// Network is "ip4:icmp" or "udp4". Empty uses "ip4:icmp".
+	Network string
+
+	// Address is the local IPv4 address the request is sent from, and
+	// so its source address. Empty uses the default described above.
+	Address string
+
+	// Family restricts the addresses of the destination that are
+	// considered. The zero value, FamilyAny, takes the first IPv4 one.
+	Family Family
+
+	// Timeout is how long to wait for each reply. Zero waits 3 seconds.
+	Timeout time.Duration
+
+	// Resolver, if not nil, looks up the destination instead of
+	// net.DefaultResolver, e.g. to query a specific DNS server.
+	Resolver *net.Resolver
+
+	// DNSTimeout, if not zero, bounds the lookup of the destination, so
+	// that a slow resolver fails the probe instead of stalling it.
+	DNSTimeout time.Duration
+
+	// ID is the ICMP identifier of the request. Zero uses the low 16
+	// bits of the process ID. ClockDiffMulti gives it to the first host
+	// and the following identifiers to the others.
+	ID int
+
+	// Seq is the ICMP sequence number of the request. Retries use the
+	// following sequence numbers.
+	Seq int
+
+	// Retries is the number of times the request is resent, with an
+	// increasing backoff, when no reply arrives before Timeout or the
+	// request was only partly sent.
+	Retries int
+
+	// TTL is the IP time-to-live of the request. Zero keeps the system
+	// default.
+	TTL int
+
+	// TOS is the IP type-of-service byte, including the DSCP, of the
+	// request. Zero keeps the system default.
+	TOS int
+
+	// Padding is the number of bytes appended to the timestamp request.
+	Padding int
+
+	// WaitAll keeps reading until Timeout after the first reply so that
+	// duplicated replies, e.g. from several anycast nodes, are reported in
+	// Result.Duplicates.
+	WaitAll bool
+
+	// Port is the local port to bind when Network is "udp4". Zero lets
+	// the kernel choose one.
+	Port int
+
+	// FixedOrigin sends Origin, in milliseconds since midnight UTC, as
+	// the originate timestamp instead of the current time, to check how
+	// the host echoes it. This is for diagnostics only: RTT, Delta and
+	// the one-way delays of the result are not computed and left zero.
+	FixedOrigin bool
+	Origin      uint32
+
+	// Header reads the replies together with their IPv4 header, through
+	// a second raw socket, and reports it in Result.Header. It requires
+	// the ip4:icmp network.
+	Header bool
+
+	// RecordRoute sends the request with the IPv4 record route option
+	// and reports the addresses recorded in the reply in Result.Route.
+	// It requires the ip4:icmp network. Where the option cannot be set,
+	// or hosts on the path strip it, Result.Route is empty.
+	RecordRoute bool
+
+	// Parallelism bounds how many hosts ClockDiffMulti probes at the
+	// same time. Zero uses DefaultParallelism.
+	Parallelism int
+
+	// OnResult, if not nil, is called with every successful measurement
+	// before ClockDiff returns it, so that callers can stream results to
+	// a channel, a metrics sink or a database as they come in.
+	OnResult func(Result)
+
+	// Logger, if not nil, receives a trace of every step of the
+	// measurement.
+	Logger *log.Logger
+}
+
+func (o Options) withDefaults() Options {
+	if o.Network == "" {
+		o.Network = "ip4:icmp"
+	}
+	if o.Address == "" {
+		o.Address = defaultAddress()
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 3 * time.Second
+	}
+	if o.ID == 0 {
+		o.ID = os.Getpid() & 0xffff
+	}
+	return o
+}
+
+func (o Options) logf(format string, v ...interface{}) {
+	if o.Logger != nil {
+		o.Logger.Printf(format, v...)
+	}
+}
+
+// Result is the outcome of a single ICMP timestamp exchange. RTT and Delta
+// are in milliseconds. Delta is the local clock minus the remote clock: it is
+// positive when the remote clock is behind the local one and negative when it
+// is ahead.
+type Result struct {
+	Host              string `json:"host"`
+	IP                net.IP `json:"resolved_ip"`
+	Peer              string `json:"peer"`
+	OriginTimestamp   uint32 `json:"origin_ms"`
+	ReceiveTimestamp  uint32 `json:"receive_ms"`
+	TransmitTimestamp uint32 `json:"transmit_ms"`
+	RTT               int64  `json:"rtt_ms"`
+	Delta             int64  `json:"delta_ms"`
+
+	// MeasuredRTT is the time from sending the request to reading the
+	// reply, measured with the local monotonic clock. Unlike RTT and
+	// Delta, which are computed from the millisecond timestamps on the
+	// wire, it has the full resolution of the clock, and it includes the
+	// processing time of the remote host. It is encoded in microseconds.
+	MeasuredRTT time.Duration `json:"-"`
+
+	// Seq is the ICMP sequence number of the request that was answered.
+	Seq int `json:"seq"`
+
+	// ForwardDelay and ReturnDelay are the one-way delays to and from
+	// the host as measured by comparing local and remote timestamps.
+	// They include the clock difference and are only comparable with
+	// each other when it is small relative to RTT.
+	ForwardDelay int64 `json:"forward_ms"`
+	ReturnDelay  int64 `json:"return_ms"`
+
+	NonStandard bool `json:"non_standard"`
+	Retries     int  `json:"retries"`
+
+	// ReplyTTL is the IP time-to-live of the reply, or 0 when the
+	// platform does not report it.
+	ReplyTTL int `json:"reply_ttl"`
+
+	// SentAt and ReceivedAt are the local wall clock times at which the
+	// request was sent and the reply received, so that callers can
+	// correct for a skewed local clock.
+	SentAt     time.Time `json:"sent_at"`
+	ReceivedAt time.Time `json:"received_at"`
+
+	// OriginTime, ReceiveTime and TransmitTime are the three timestamps
+	// of the reply as absolute times, taking into account that the
+	// remote ones may fall on the day before or after the origin.
+	OriginTime   time.Time `json:"origin_time"`
+	ReceiveTime  time.Time `json:"receive_time"`
+	TransmitTime time.Time `json:"transmit_time"`
+
+	// ClockStepped is set when the local wall clock was changed during the
+	// exchange, e.g. stepped by NTP, making RTT and Delta unreliable. It
+	// is detected by comparing the wall clock and monotonic clock
+	// durations of the exchange.
+	ClockStepped bool `json:"clock_stepped"`
+
+	// ReceiveValid and TransmitValid report whether the host filled in
+	// the receive and transmit timestamps. Some hosts leave one of them
+	// zero; RTT and Delta are then computed from the other one alone,
+	// and are meaningless if both are zero.
+	ReceiveValid  bool `json:"receive_valid"`
+	TransmitValid bool `json:"transmit_valid"`
+
+	// Header is the IPv4 header of the reply when Options.Header is set.
+	Header *ipv4.Header `json:"ip_header,omitempty"`
+
+	// Route holds the addresses recorded in the reply when
+	// Options.RecordRoute is set.
+	Route []net.IP `json:"route,omitempty"`
+
+	// Duplicates holds the further replies to the same request received
+	// when Options.WaitAll is set.
+	Duplicates []Result `json:"duplicates,omitempty"`
+}
+
+func getAddr(ctx context.Context, host string, c packetConn, opts Options) (net.Addr, error) {
+	ips, err := LookupIPv4(ctx, host, opts)
+	if err != nil {
+		return nil, err
+	}
+	switch c.LocalAddr().(type) {
+	case *net.UDPAddr:
+		return &net.UDPAddr{IP: ips[0]}, nil
+	case *net.IPAddr:
+		return &net.IPAddr{IP: ips[0]}, nil
+	default:
+		return nil, errors.New("neither UDPAddr nor IPAddr")
+	}
+}
+
+// LookupIPv4 returns the IPv4 addresses of host, in the order of the
+// resolver, or host itself if it is an IPv4 address. ClockDiff probes the
+// first one. Resolution errors wrap ErrResolve, and ErrNoTimestampOverIPv6 is
+// returned if host only has IPv6 addresses or opts.Family is FamilyInet6.
+// The lookup uses opts.Resolver and is bounded by opts.DNSTimeout.
+func LookupIPv4(ctx context.Context, host string, opts Options) ([]net.IP, error) {
+	r := opts.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	if opts.DNSTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.DNSTimeout)
+		defer cancel()
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		var err error
+		ips, err = r.LookupIP(ctx, "ip", host)
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			// The error is the same whether the name does not
+			// exist or has no address records.
+			if exists, ok := nameExists(ctx, r, host); !ok {
+				return nil, fmt.Errorf("%w: %w", ErrResolve, err)
+			} else if !exists {
+				return nil, fmt.Errorf("%w: %w: %s", ErrResolve, ErrNoSuchHost, host)
+			}
+			return nil, fmt.Errorf("%w: %w: %s", ErrResolve, ErrNoAddress, describeName(ctx, r, host))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrResolve, err)
+		}
+	}
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	switch opts.Family {
+	case FamilyInet6:
+		if len(v6) > 0 {
+			return nil, ErrNoTimestampOverIPv6
+		}
+		return nil, fmt.Errorf("%w: %w: %s has no AAAA record", ErrResolve, ErrNoAddress, describeName(ctx, r, host))
+	case FamilyInet:
+		if len(v4) == 0 {
+			return nil, fmt.Errorf("%w: %w: %s has no A record", ErrResolve, ErrNoAddress, describeName(ctx, r, host))
+		}
+	}
+	if len(v4) > 0 {
+		return v4, nil
+	}
+	if len(v6) > 0 {
+		return nil, ErrNoTimestampOverIPv6
+	}
+	return nil, fmt.Errorf("%w: %w: %s has no A or AAAA record", ErrResolve, ErrNoAddress, describeName(ctx, r, host))
+}
+
+// describeName returns host together with its canonical name, if it is an
+// alias, for resolution errors.
+func describeName(ctx context.Context, r *net.Resolver, host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	cname, err := r.LookupCNAME(ctx, host)
+	if err != nil {
+		return host
+	}
+	cname = strings.TrimSuffix(cname, ".")
+	if strings.EqualFold(cname, strings.TrimSuffix(host, ".")) {
+		return host
+	}
+	return fmt.Sprintf("%s (CNAME %s)", host, cname)
+}
+
+func addrIP(a net.Addr) net.IP {
+	switch a := a.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+const msPerDay = 24 * 60 * 60 * 1000
+
+// msTime converts the millisecond-of-day value ms to a time on the day of
+// midnight, or the day before or after it, whichever is closest to ref.
+func msTime(midnight time.Time, ms, ref int64) time.Time {
+	return midnight.Add(time.Duration(unwrap(ms, ref)) * time.Millisecond)
+}
+
+const retryBackoff = 100 * time.Millisecond
+
+// unwrap moves the millisecond-of-day value t into the day closest to ref, so
+// that timestamps taken on either side of midnight UTC compare correctly.
+func unwrap(t, ref int64) int64 {
+	for t-ref > msPerDay/2 {
+		t -= msPerDay
+	}
+	for ref-t > msPerDay/2 {
+		t += msPerDay
+	}
+	return t
+}
+
+// diff computes the round-trip time and clock difference from the local
+// transmit time, the remote receive and transmit timestamps and the local
+// receive time, all in milliseconds since midnight UTC, using the NTP delay
+// and offset equations. The NTP offset is remote minus local, so delta is its
+// negation.
+func diff(transmitTime, remoteReceiveTime, remoteTransmitTime, receivedTime int64) (rtt, delta int64) {
+	remoteReceiveTime = unwrap(remoteReceiveTime, transmitTime)
+	remoteTransmitTime = unwrap(remoteTransmitTime, transmitTime)
+	rtt = (receivedTime - transmitTime) - (remoteTransmitTime - remoteReceiveTime)
+	offset := ((remoteReceiveTime - transmitTime) + (remoteTransmitTime - receivedTime)) / 2
+	return rtt, -offset
+}
+
+// oneWay computes the forward and return delays from the same timestamps as
+// diff. Each of them is offset by the clock difference, with opposite signs.
+func oneWay(transmitTime, remoteReceiveTime, remoteTransmitTime, receivedTime int64) (forward, back int64) {
+	remoteReceiveTime = unwrap(remoteReceiveTime, transmitTime)
+	remoteTransmitTime = unwrap(remoteTransmitTime, transmitTime)
+	return remoteReceiveTime - transmitTime, receivedTime - remoteTransmitTime
+}
+
+// ClockDiff sends a single ICMP timestamp request to host and computes the
+// clock difference from the reply.
+func ClockDiff(host string, opts Options) (Result, error) {
+	return ClockDiffContext(context.Background(), host, opts)
+}
+
+// ClockDiffContext is like ClockDiff but aborts the measurement as soon as
+// ctx is done, returning ctx.Err().
+func ClockDiffContext(ctx context.Context, host string, opts Options) (Result, error) {
+	opts = opts.withDefaults()
+	if opts.Padding < 0 {
+		return Result{}, fmt.Errorf("invalid padding %d: must not be negative", opts.Padding)
+	}
+	c, p, err := listen(opts)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return Result{}, fmt.Errorf("%w: %w", ErrPermission, err)
+		}
+		return Result{}, err
+	}
+	defer c.Close()
+	opts.logf("listening on %s %v", opts.Network, c.LocalAddr())
+
+	if opts.TTL != 0 {
+		if err := p.SetTTL(opts.TTL); err != nil {
+			return Result{}, err
+		}
+	}
+	if opts.TOS != 0 {
+		if err := p.SetTOS(opts.TOS); err != nil {
+			return Result{}, fmt.Errorf("cannot set TOS %#x: %w", opts.TOS, err)
+		}
+	}
+	// The reply TTL is informational only; platforms without control
+	// message support simply report it as unknown.
+	p.SetControlMessage(ipv4.FlagTTL, true)
+
+	var rc *ipv4.RawConn
+	if opts.Header || opts.RecordRoute {
+		if rc, err = listenRaw(opts); err != nil {
+			return Result{}, err
+		}
+		defer rc.Close()
+	}
+
+	conn := &icmpConn{c: c, p: p, rc: rc}
+	dst, err := getAddr(ctx, host, conn, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	opts.logf("resolved %s to %v", host, dst)
+	return probe(ctx, conn, host, dst, opts)
+}
+
+// probe measures the clock difference of host at dst over c, resending the
+// request up to opts.Retries times after a timeout or a short write.
+func probe(ctx context.Context, c packetConn, host string, dst net.Addr, opts Options) (Result, error) {
+	seq := opts.Seq & 0xffff
+	for retries := 0; ; retries++ {
+		r, err := exchange(ctx, c, dst, (seq+retries)&0xffff, opts)
+		if err == nil {
+			r.Host = host
+			r.Retries = retries
+			if opts.OnResult != nil {
+				opts.OnResult(r)
+			}
+			return r, nil
+		}
+		if retries >= opts.Retries || !errors.Is(err, ErrTimeout) && !errors.Is(err, ErrShortWrite) {
+			return Result{}, err
+		}
+		select {
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		case <-time.After(retryBackoff << uint(retries)):
+		}
+	}
+}
+
+// isOurRequest reports whether data, the original datagram embedded in an
+// ICMP error message, is the timestamp request req.
+func isOurRequest(data []byte, req *Timestamp) bool {
+	h, err := ipv4.ParseHeader(data)
+	if err != nil || len(data) < h.Len+8 {
+		return false
+	}
+	m := data[h.Len:]
+	id := int(m[4])<<8 | int(m[5])
+	seq := int(m[6])<<8 | int(m[7])
+	return m[0] == byte(ipv4.ICMPTypeTimestamp) && id == req.ID && seq == req.Seq
+}
+
+func replyTTL(cm *ipv4.ControlMessage) int {
+	if cm == nil {
+		return 0
+	}
+	return cm.TTL
+}
+
+// usableTimestamps returns the remote receive and transmit timestamps of ts,
+// substituting one for the other if the host left it zero.
+func usableTimestamps(ts *Timestamp) (receive, transmit uint32) {
+	receive, transmit = ts.ReceiveTimestamp, ts.TransmitTimestamp
+	switch {
+	case receive == 0:
+		receive = transmit
+	case transmit == 0:
+		transmit = receive
+	}
+	return receive, transmit
+}
+
+// transient reports whether err is a read error worth retrying, such as an
+// interrupted system call, as opposed to the deadline or a fatal error.
+// EAGAIN is not checked: the runtime poller waits for the socket instead of
+// returning it.
+func transient(err error) bool {
+	if errors.Is(err, syscall.EINTR) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return false
+	}
+	var te interface{ Temporary() bool }
+	return errors.As(err, &te) && te.Temporary()
+}
+
+// clockStepThreshold is how far the wall clock and monotonic clock durations
+// of an exchange may disagree before the wall clock is considered stepped.
+const clockStepThreshold = 10 * time.Millisecond
+
+// clockStepped reports whether the wall clock was changed between the two
+// readings of time.Now, which carry both clocks.
+func clockStepped(sent, received time.Time) bool {
+	return wallStepped(received.Sub(sent), received.Round(0).Sub(sent.Round(0)))
+}
+
+// wallStepped reports whether the wall clock duration of an exchange is
+// further than clockStepThreshold from its monotonic clock duration.
+func wallStepped(mono, wall time.Duration) bool {
+	d := wall - mono
+	return d > clockStepThreshold || d < -clockStepThreshold
+}
+
+// requestBuffers and replyBuffers hold the buffers requests are marshalled
+// into and replies read into, so that continuous probing does not allocate
+// them per request.
+var (
+	requestBuffers = sync.Pool{New: func() interface{} { return new([]byte) }}
+	replyBuffers   = sync.Pool{New: func() interface{} { return new([]byte) }}
+)
+
+// readResult holds the return values of packetConn.ReadFrom for readContext.
+type readResult struct {
+	n    int
+	h    *ipv4.Header
+	cm   *ipv4.ControlMessage
+	peer net.Addr
+	err  error
+}
+
+// readContext reads from c in a goroutine until the read returns or ctx is
+// done. In the latter case it closes c, which makes the read return, and
+// waits for it, so that the goroutine neither outlives the call nor writes to
+// b afterwards. Timeouts are still left to the read deadline: giving up on
+// a timer would leave a read pending that could consume the next reply.
+// Contexts that are never done, like context.Background, are read from
+// directly.
+func readContext(ctx context.Context, c packetConn, b []byte) (int, *ipv4.Header, *ipv4.ControlMessage, net.Addr, error) {
+	if ctx.Done() == nil {
+		return c.ReadFrom(b)
+	}
+	ch := make(chan readResult, 1)
+	go func() {
+		var r readResult
+		r.n, r.h, r.cm, r.peer, r.err = c.ReadFrom(b)
+		ch <- r
+	}()
+	select {
+	case r := <-ch:
+		return r.n, r.h, r.cm, r.peer, r.err
+	case <-ctx.Done():
+		c.Close()
+		<-ch
+		return 0, nil, nil, nil, ctx.Err()
+	}
+}
+
+// exchange sends a single timestamp request with the given sequence number
+// to dst over c and waits up to opts.Timeout for the matching reply.
+func exchange(ctx context.Context, c packetConn, dst net.Addr, seq int, opts Options) (Result, error) {
+	now := time.Now()
+	midnight := now.Truncate(24 * time.Hour)
+	today := midnight.UnixNano() / 1000000
+	transmitTime := uint32(now.UnixNano()/1000000 - today)
+	origin := transmitTime
+	if opts.FixedOrigin {
+		origin = opts.Origin
+	}
+	req := &Timestamp{
+		ID: opts.ID & 0xffff, Seq: seq,
+		OriginTimestamp: origin,
+		Padding:         opts.Padding,
+	}
+	bp := requestBuffers.Get().(*[]byte)
+	defer requestBuffers.Put(bp)
+	wb, err := marshalRequest(*bp, req)
+	if err != nil {
+		return Result{}, err
+	}
+	*bp = wb
+	sent := time.Now()
+	if n, err := c.WriteTo(wb, dst); err != nil {
+		if ctx.Err() != nil {
+			return Result{}, ctx.Err()
+		}
+		if opts.Network == "udp4" && errors.Is(err, syscall.EINVAL) {
+			return Result{}, fmt.Errorf("%w: %w", ErrDatagramUnsupported, err)
+		}
+		return Result{}, err
+	} else if n != len(wb) {
+		return Result{}, fmt.Errorf("%w: got %v; want %v", ErrShortWrite, n, len(wb))
+	}
+	opts.logf("wrote %d bytes to %v: id=%d seq=%d originate=%d", len(wb), dst, req.ID, req.Seq, req.OriginTimestamp)
+
+	rp := replyBuffers.Get().(*[]byte)
+	defer replyBuffers.Put(rp)
+	if cap(*rp) < 1500+opts.Padding {
+		*rp = make([]byte, 1500+opts.Padding)
+	}
+	rb := (*rp)[:cap(*rp)]
+	deadline := time.Now().Add(opts.Timeout)
+	if err := c.SetReadDeadline(deadline); err != nil {
+		return Result{}, err
+	}
+	// With WaitAll, first is the earliest matching reply and later ones
+	// are collected as its duplicates until the deadline.
+	var first *Result
+	// ignored is the last unrelated packet read, reported if no reply
+	// arrives.
+	var ignored error
+	for {
+		n, h, cm, peer, err := readContext(ctx, c, rb)
+		if err != nil {
+			if ctx.Err() != nil {
+				return Result{}, ctx.Err()
+			}
+			if transient(err) {
+				// The read deadline still bounds the retries.
+				opts.logf("retrying read after transient error: %v", err)
+				continue
+			}
+			if first != nil {
+				return *first, nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if ignored != nil {
+					return Result{}, fmt.Errorf("%w: %w; %w", ErrTimeout, err, ignored)
+				}
+				return Result{}, fmt.Errorf("%w: %w", ErrTimeout, err)
+			}
+			return Result{}, err
+		}
+		receivedAt := time.Now()
+		receivedTime := receivedAt.UnixNano()/1000000 - today
+		opts.logf("read %d bytes from %v: %x", n, peer, rb[:n])
+		rm, err := icmp.ParseMessage(protocolICMP, rb[:n])
+		if err != nil {
+			return Result{}, fmt.Errorf("cannot parse reply from %v: %w; reply: %s", peer, err, hex.EncodeToString(rb[:n]))
+		}
+		if first != nil && rm.Type != ipv4.ICMPTypeTimestampReply {
+			continue
+		}
+		switch rm.Type {
+		case ipv4.ICMPTypeTimestampReply:
+			b, err := rm.Body.Marshal(protocolICMP)
+			if err != nil {
+				return Result{}, err
+			}
+			ts, err := ParseTimestamp(b)
+			if err != nil {
+				return Result{}, fmt.Errorf("ParseTimestamp error: %w; reply from %v: %s", err, peer, hex.EncodeToString(rb[:n]))
+			}
+			if ts.ID != req.ID || ts.Seq != req.Seq {
+				// A reply to another process or to an earlier probe.
+				continue
+			}
+			opts.logf("parsed reply: id=%d seq=%d originate=%d receive=%d transmit=%d; local receive=%d", ts.ID, ts.Seq, ts.OriginTimestamp, ts.ReceiveTimestamp, ts.TransmitTimestamp, receivedTime)
+			remoteReceive, remoteTransmit := usableTimestamps(ts)
+			var rtt, delta, forward, back int64
+			if !opts.FixedOrigin {
+				rtt, delta = diff(int64(transmitTime), int64(remoteReceive), int64(remoteTransmit), receivedTime)
+				forward, back = oneWay(int64(transmitTime), int64(remoteReceive), int64(remoteTransmit), receivedTime)
+			}
+			opts.logf("receive-originate=%d localreceive-transmit=%d rtt=%d delta=%d", forward, back, rtt, delta)
+			r := Result{
+				IP:                addrIP(dst),
+				Peer:              peer.String(),
+				Seq:               ts.Seq,
+				OriginTimestamp:   ts.OriginTimestamp,
+				ReceiveTimestamp:  ts.ReceiveTimestamp,
+				TransmitTimestamp: ts.TransmitTimestamp,
+				RTT:               rtt,
+				Delta:             delta,
+				MeasuredRTT:       receivedAt.Sub(sent),
+				ForwardDelay:      forward,
+				ReturnDelay:       back,
+				NonStandard:       ts.NonStandard,
+				ReplyTTL:          replyTTL(cm),
+				Route:             recordedRoute(h),
+				SentAt:            now,
+				ReceivedAt:        receivedAt,
+				OriginTime:        msTime(midnight, int64(ts.OriginTimestamp), int64(transmitTime)),
+				ReceiveTime:       msTime(midnight, int64(ts.ReceiveTimestamp), int64(transmitTime)),
+				TransmitTime:      msTime(midnight, int64(ts.TransmitTimestamp), int64(transmitTime)),
+				ClockStepped:      clockStepped(now, receivedAt),
+				ReceiveValid:      ts.ReceiveTimestamp != 0,
+				TransmitValid:     ts.TransmitTimestamp != 0,
+			}
+			if h != nil {
+				r.ReplyTTL = h.TTL
+			}
+			if opts.Header {
+				r.Header = h
+			}
+			if r.ClockStepped {
+				opts.logf("local wall clock changed during the exchange")
+			}
+			switch {
+			case !opts.WaitAll:
+				return r, nil
+			case first == nil:
+				first = &r
+			default:
+				first.Duplicates = append(first.Duplicates, r)
+			}
+		case ipv4.ICMPTypeDestinationUnreachable:
+			body, ok := rm.Body.(*icmp.DstUnreach)
+			if !ok || !isOurRequest(body.Data, req) {
+				continue
+			}
+			reason, ok := unreachableCodes[rm.Code]
+			if !ok {
+				reason = fmt.Sprintf("code %d", rm.Code)
+			}
+			return Result{}, fmt.Errorf("%w: %s, reported by %v; the host or a firewall on the path does not allow ICMP timestamp requests", ErrDestinationUnreachable, reason, peer)
+		case ipv4.ICMPTypeTimeExceeded:
+			body, ok := rm.Body.(*icmp.TimeExceeded)
+			if !ok || !isOurRequest(body.Data, req) {
+				continue
+			}
+			return Result{}, fmt.Errorf("%w: reported by %v; increase the TTL or check for a routing loop", ErrTimeExceeded, peer)
+		default:
+			// Other ICMP traffic, such as the echo replies of a
+			// concurrent ping or our own request on the loopback
+			// interface, is seen by raw sockets too. Keep reading
+			// until our reply arrives.
+			ignored = fmt.Errorf("%w: got %v (type %d, code %d) from %v; want timestamp reply", ErrUnexpectedReply, rm.Type, rm.Type, rm.Code, peer)
+			opts.logf("ignoring packet: %v", ignored)
+		}
+	}
+}
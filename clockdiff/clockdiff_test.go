@@ -0,0 +1,471 @@
+package clockdiff
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func TestUnwrapMidnight(t *testing.T) {
+	tests := []struct {
+		t, ref, want int64
+	}{
+		{100, 50, 100},
+		{5, 86399990, 86400005},
+		{86399995, 10, -5},
+		{msPerDay / 2, 0, msPerDay / 2},
+		{msPerDay/2 + 1, 0, msPerDay/2 + 1 - msPerDay},
+	}
+	for _, tt := range tests {
+		if got := unwrap(tt.t, tt.ref); got != tt.want {
+			t.Errorf("unwrap(%d, %d) = %d, want %d", tt.t, tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestDiffMidnight(t *testing.T) {
+	tests := []struct {
+		name                                string
+		transmit, receive, remote, received int64
+		rtt, delta                          int64
+	}{
+		// Sent just before midnight, stamped by the remote host just
+		// after it.
+		{"remote past midnight", 86399990, 5, 6, 86400015, 24, -3},
+		// Sent just after midnight, stamped by a remote clock still
+		// on the day before.
+		{"remote before midnight", 5, 86399995, 86399996, 20, 14, 17},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rtt, delta := diff(tt.transmit, tt.receive, tt.remote, tt.received)
+			if rtt != tt.rtt || delta != tt.delta {
+				t.Errorf("diff = rtt %d, delta %d; want rtt %d, delta %d", rtt, delta, tt.rtt, tt.delta)
+			}
+		})
+	}
+}
+
+// syntheticTimestamps returns the four timestamps of an exchange sent at
+// transmit over a path taking forward and back milliseconds, to a host
+// whose clock is offset milliseconds ahead of the local one and takes
+// processing milliseconds to reply.
+func syntheticTimestamps(transmit, forward, back, processing, offset int64) (t1, t2, t3, t4 int64) {
+	t1 = transmit
+	t2 = transmit + forward + offset
+	t3 = t2 + processing
+	t4 = transmit + forward + processing + back
+	return t1, t2, t3, t4
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name                              string
+		forward, back, processing, offset int64
+		rtt, delta                        int64
+		forwardDelay, returnDelay         int64
+	}{
+		{"synchronized", 10, 10, 0, 0, 20, 0, 10, 10},
+		{"remote ahead", 10, 10, 2, 50, 20, -50, 60, -40},
+		{"remote behind", 10, 10, 2, -50, 20, 50, -40, 60},
+		{"slow remote", 5, 5, 30, 7, 10, -7, 12, -2},
+		// An odd sum of the one-way delays rounds the offset toward
+		// zero.
+		{"odd forward", 3, 0, 0, 0, 3, -1, 3, 0},
+		{"odd return", 0, 3, 0, 0, 3, 1, 0, 3},
+		{"odd behind", 3, 0, 0, -10, 3, 8, -7, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t1, t2, t3, t4 := syntheticTimestamps(40000000, tt.forward, tt.back, tt.processing, tt.offset)
+			rtt, delta := diff(t1, t2, t3, t4)
+			if rtt != tt.rtt || delta != tt.delta {
+				t.Errorf("diff = rtt %d, delta %d; want rtt %d, delta %d", rtt, delta, tt.rtt, tt.delta)
+			}
+			forward, back := oneWay(t1, t2, t3, t4)
+			if forward != tt.forwardDelay || back != tt.returnDelay {
+				t.Errorf("oneWay = %d, %d; want %d, %d", forward, back, tt.forwardDelay, tt.returnDelay)
+			}
+		})
+	}
+}
+
+func benchmarkExchange(b *testing.B, ctx context.Context) {
+	c := newFakeConn(offsetReplies(0))
+	dst := &net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}
+	opts := Options{ID: 0x4242, Timeout: time.Second}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := exchange(ctx, c, dst, i&0xffff, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExchange(b *testing.B) {
+	benchmarkExchange(b, context.Background())
+}
+
+func BenchmarkExchangeCancelable(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	benchmarkExchange(b, ctx)
+}
+
+// echoReply returns an echo reply with the ID and sequence number of the
+// timestamp request req, as a concurrent ping would receive.
+func echoReply(req []byte) []byte {
+	ts, err := ParseTimestamp(req[4:])
+	if err != nil {
+		panic(err)
+	}
+	b, err := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: ts.ID, Seq: ts.Seq, Data: []byte("ping")}}).Marshal(nil)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestExchangeSkipsEchoReply(t *testing.T) {
+	c := newFakeConn(func(req []byte) [][]byte {
+		stale := append([]byte(nil), req...)
+		stale[7]++ // the sequence number of another probe
+		return [][]byte{echoReply(req), timestampReply(stale, 0), timestampReply(req, 0)}
+	})
+	dst := &net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}
+	r, err := exchange(context.Background(), c, dst, 7, Options{ID: 0x4242, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if r.Seq != 7 {
+		t.Errorf("Seq = %d, want 7", r.Seq)
+	}
+	if r.Peer != "192.0.2.1" {
+		t.Errorf("Peer = %q, want 192.0.2.1", r.Peer)
+	}
+}
+
+func TestExchangeOnlyEchoReply(t *testing.T) {
+	c := newFakeConn(func(req []byte) [][]byte {
+		return [][]byte{echoReply(req)}
+	})
+	dst := &net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}
+	_, err := exchange(context.Background(), c, dst, 7, Options{ID: 0x4242, Timeout: 20 * time.Millisecond})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("exchange error = %v, want ErrTimeout", err)
+	}
+	if !errors.Is(err, ErrUnexpectedReply) {
+		t.Errorf("exchange error = %v, want it to report the echo reply", err)
+	}
+}
+
+func TestReadContextCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	c := newFakeConn(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, _, _, _, err := readContext(ctx, c, make([]byte, 1500))
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		t.Fatalf("readContext returned %v before the context was cancelled", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Errorf("readContext error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("readContext did not return after the context was cancelled")
+	}
+	c.mu.Lock()
+	closes := c.closes
+	c.mu.Unlock()
+	if closes != 1 {
+		t.Errorf("conn closed %d times, want once", closes)
+	}
+	// The reading goroutine has to be gone too.
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Fatalf("%d goroutines left, want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReadContextBackground(t *testing.T) {
+	c := newFakeConn(nil)
+	c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, _, _, err := readContext(context.Background(), c, make([]byte, 1500))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("readContext error = %v, want the read deadline to expire", err)
+	}
+	if c.closes != 0 {
+		t.Errorf("conn closed %d times, want it left open", c.closes)
+	}
+}
+
+func TestExchangeOffset(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset time.Duration
+		delta  int64
+	}{
+		{"in sync", 0, 0},
+		{"remote ahead", 250 * time.Millisecond, -250},
+		{"remote behind", -1500 * time.Millisecond, 1500},
+		{"remote an hour ahead", time.Hour, -3600000},
+	}
+	dst := &net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeConn(offsetReplies(tt.offset))
+			r, err := exchange(context.Background(), c, dst, 1, Options{ID: 0x4242, Timeout: time.Second})
+			if err != nil {
+				t.Fatalf("exchange: %v", err)
+			}
+			// The replies are immediate, so only the millisecond
+			// truncation of the timestamps separates delta from the
+			// offset.
+			if d := r.Delta - tt.delta; d < -2 || d > 2 {
+				t.Errorf("delta = %d, want %d", r.Delta, tt.delta)
+			}
+			if r.RTT < 0 || r.RTT > 2 {
+				t.Errorf("rtt = %d, want about 0", r.RTT)
+			}
+			if !r.IP.Equal(dst.IP) {
+				t.Errorf("IP = %v, want %v", r.IP, dst.IP)
+			}
+		})
+	}
+}
+
+func TestProbeShortWrite(t *testing.T) {
+	for _, retries := range []int{0, 2} {
+		c := newFakeConn(offsetReplies(0))
+		c.short = true
+		dst := &net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}
+		_, err := probe(context.Background(), c, "192.0.2.1", dst, Options{ID: 0x4242, Timeout: time.Second, Retries: retries})
+		if !errors.Is(err, ErrShortWrite) {
+			t.Errorf("retries %d: probe error = %v, want ErrShortWrite", retries, err)
+		}
+		if c.writes != retries+1 {
+			t.Errorf("retries %d: %d requests written, want %d", retries, c.writes, retries+1)
+		}
+	}
+}
+
+func TestProbeRetriesShortWrite(t *testing.T) {
+	c := newFakeConn(offsetReplies(0))
+	c.short = true
+	// Only the first request is cut short.
+	c.onWrite = func() { c.short = false }
+	dst := &net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}
+	r, err := probe(context.Background(), c, "192.0.2.1", dst, Options{ID: 0x4242, Timeout: time.Second, Retries: 1})
+	if err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if r.Retries != 1 || r.Seq != 1 {
+		t.Errorf("Retries = %d, Seq = %d; want the second request, seq 1, to be answered", r.Retries, r.Seq)
+	}
+}
+
+// temporaryError is an error whose Temporary method reports temporary.
+type temporaryError struct {
+	temporary bool
+}
+
+func (e temporaryError) Error() string   { return "temporary error" }
+func (e temporaryError) Temporary() bool { return e.temporary }
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"EINTR", syscall.EINTR, true},
+		{"wrapped EINTR", &net.OpError{Op: "read", Net: "ip4:icmp", Err: os.NewSyscallError("recvfrom", syscall.EINTR)}, true},
+		{"deadline", os.ErrDeadlineExceeded, false},
+		{"wrapped deadline", &net.OpError{Op: "read", Net: "ip4:icmp", Err: os.ErrDeadlineExceeded}, false},
+		{"closed", net.ErrClosed, false},
+		{"temporary", temporaryError{true}, true},
+		{"not temporary", temporaryError{false}, false},
+		{"other", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		if got := transient(tt.err); got != tt.want {
+			t.Errorf("%s: transient(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+// icmpError returns the ICMP error message of type typ and code about the
+// request req, which it quotes after an IPv4 header like a router would.
+func icmpError(typ icmp.Type, code int, req []byte) []byte {
+	h := &ipv4.Header{
+		Version: ipv4.Version, Len: ipv4.HeaderLen, TotalLen: ipv4.HeaderLen + len(req),
+		TTL: 1, Protocol: protocolICMP, Src: net.IPv4(192, 0, 2, 100), Dst: net.IPv4(192, 0, 2, 1),
+	}
+	hb, err := h.Marshal()
+	if err != nil {
+		panic(err)
+	}
+	data := append(hb, req...)
+	var body icmp.MessageBody = &icmp.DstUnreach{Data: data}
+	if typ == ipv4.ICMPTypeTimeExceeded {
+		body = &icmp.TimeExceeded{Data: data}
+	}
+	b, err := (&icmp.Message{Type: typ, Code: code, Body: body}).Marshal(nil)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestExchangeICMPErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply func(req []byte) [][]byte
+		want  error
+	}{
+		{
+			"unreachable",
+			func(req []byte) [][]byte {
+				return [][]byte{icmpError(ipv4.ICMPTypeDestinationUnreachable, 1, req)}
+			},
+			ErrDestinationUnreachable,
+		},
+		{
+			"time exceeded",
+			func(req []byte) [][]byte {
+				return [][]byte{icmpError(ipv4.ICMPTypeTimeExceeded, 0, req)}
+			},
+			ErrTimeExceeded,
+		},
+		{
+			"unreachable for another probe",
+			func(req []byte) [][]byte {
+				other := append([]byte(nil), req...)
+				other[7]++
+				return [][]byte{icmpError(ipv4.ICMPTypeDestinationUnreachable, 1, other), timestampReply(req, 0)}
+			},
+			nil,
+		},
+		{
+			"time exceeded for another process",
+			func(req []byte) [][]byte {
+				other := append([]byte(nil), req...)
+				other[5]++
+				return [][]byte{icmpError(ipv4.ICMPTypeTimeExceeded, 0, other), timestampReply(req, 0)}
+			},
+			nil,
+		},
+	}
+	dst := &net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeConn(tt.reply)
+			_, err := exchange(context.Background(), c, dst, 7, Options{ID: 0x4242, Timeout: time.Second})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("exchange error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClockStepped(t *testing.T) {
+	sent := time.Now()
+	// Both readings carry the monotonic clock, which Add advances
+	// together with the wall clock.
+	if clockStepped(sent, sent.Add(50*time.Millisecond)) {
+		t.Error("clockStepped of a steady exchange is true")
+	}
+	// Without a monotonic reading, both durations come from the wall
+	// clock and always agree.
+	if clockStepped(sent.Round(0), sent.Round(0).Add(time.Hour)) {
+		t.Error("clockStepped of wall clock times is true")
+	}
+	// time.Now cannot be made to step, so check the durations of
+	// exchanges during which the wall clock did or did not.
+	tests := []struct {
+		mono, wall time.Duration
+		want       bool
+	}{
+		{50 * time.Millisecond, 50 * time.Millisecond, false},
+		{50 * time.Millisecond, 50*time.Millisecond + clockStepThreshold, false},
+		{50 * time.Millisecond, 50*time.Millisecond - clockStepThreshold, false},
+		{50 * time.Millisecond, 50*time.Millisecond + clockStepThreshold + 1, true},
+		{50 * time.Millisecond, 50*time.Millisecond - clockStepThreshold - 1, true},
+		{50 * time.Millisecond, time.Second, true},
+		{50 * time.Millisecond, -time.Second, true},
+	}
+	for _, tt := range tests {
+		if got := wallStepped(tt.mono, tt.wall); got != tt.want {
+			t.Errorf("wallStepped(%s, %s) = %v, want %v", tt.mono, tt.wall, got, tt.want)
+		}
+	}
+}
+
+// editedReply returns the timestamp reply to req of a host whose clock is
+// offset ahead of the local one, changed by edit.
+func editedReply(req []byte, offset time.Duration, edit func(ts *Timestamp)) []byte {
+	ts, err := ParseTimestamp(timestampReply(req, offset)[4:])
+	if err != nil {
+		panic(err)
+	}
+	edit(ts)
+	b, err := (&icmp.Message{Type: ipv4.ICMPTypeTimestampReply, Body: ts}).Marshal(nil)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestExchangeMissingTimestamp(t *testing.T) {
+	const offset = 250 * time.Millisecond
+	tests := []struct {
+		name                        string
+		edit                        func(ts *Timestamp)
+		receiveValid, transmitValid bool
+	}{
+		{"both", func(ts *Timestamp) {}, true, true},
+		{"no receive", func(ts *Timestamp) { ts.ReceiveTimestamp = 0 }, false, true},
+		{"no transmit", func(ts *Timestamp) { ts.TransmitTimestamp = 0 }, true, false},
+		{"neither", func(ts *Timestamp) { ts.ReceiveTimestamp, ts.TransmitTimestamp = 0, 0 }, false, false},
+	}
+	dst := &net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeConn(func(req []byte) [][]byte {
+				return [][]byte{editedReply(req, offset, tt.edit)}
+			})
+			r, err := exchange(context.Background(), c, dst, 1, Options{ID: 0x4242, Timeout: time.Second})
+			if err != nil {
+				t.Fatalf("exchange: %v", err)
+			}
+			if r.ReceiveValid != tt.receiveValid || r.TransmitValid != tt.transmitValid {
+				t.Errorf("ReceiveValid = %v, TransmitValid = %v; want %v and %v", r.ReceiveValid, r.TransmitValid, tt.receiveValid, tt.transmitValid)
+			}
+			if !tt.receiveValid && !tt.transmitValid {
+				return
+			}
+			// The remaining timestamp stands in for the missing one.
+			if d := r.Delta + 250; d < -2 || d > 2 {
+				t.Errorf("delta = %d, want -250", r.Delta)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package clockdiff_test
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/higebu/goclockdiff/clockdiff"
+)
+
+// Stream the measurements of a series of probes to a channel as they come
+// in, e.g. to feed a live dashboard.
+func ExampleOptions_onResult() {
+	results := make(chan clockdiff.Result)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for r := range results {
+			fmt.Printf("%v seq=%d delta=%dms rtt=%dms\n", r.IP, r.Seq, r.Delta, r.RTT)
+		}
+	}()
+
+	opts := clockdiff.Options{
+		OnResult: func(r clockdiff.Result) { results <- r },
+	}
+	for seq := 0; seq < 5; seq++ {
+		opts.Seq = seq
+		if _, err := clockdiff.ClockDiff("192.0.2.1", opts); err != nil {
+			log.Print(err)
+		}
+	}
+	close(results)
+	<-done
+}
@@ -0,0 +1,127 @@
+package clockdiff
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// packetConn is the connection exchange sends requests and reads replies
+// over. It is a net.PacketConn whose ReadFrom also returns the IPv4 header
+// and control message of the packet, when available, so that it can be
+// replaced by a fake one or another transport.
+type packetConn interface {
+	WriteTo(b []byte, dst net.Addr) (int, error)
+	ReadFrom(b []byte) (n int, h *ipv4.Header, cm *ipv4.ControlMessage, peer net.Addr, err error)
+	SetReadDeadline(t time.Time) error
+	Close() error
+	LocalAddr() net.Addr
+}
+
+// icmpConn is the packetConn of ClockDiff. Requests are written to c and
+// replies read from its IPv4 view p or, if rc is not nil, from rc together
+// with their IPv4 header.
+type icmpConn struct {
+	c  net.PacketConn
+	p  *ipv4.PacketConn
+	rc *ipv4.RawConn
+}
+
+func (c *icmpConn) WriteTo(b []byte, dst net.Addr) (int, error) {
+	return c.c.WriteTo(b, dst)
+}
+
+// ReadFrom reads the ICMP message of the next packet into the start of b.
+func (c *icmpConn) ReadFrom(b []byte) (int, *ipv4.Header, *ipv4.ControlMessage, net.Addr, error) {
+	if c.rc == nil {
+		n, cm, peer, err := c.p.ReadFrom(b)
+		return n, nil, cm, peer, err
+	}
+	h, payload, cm, err := c.rc.ReadFrom(b)
+	if err != nil {
+		return 0, nil, nil, nil, err
+	}
+	n := copy(b, payload)
+	return n, h, cm, &net.IPAddr{IP: h.Src}, nil
+}
+
+func (c *icmpConn) SetReadDeadline(t time.Time) error {
+	if err := c.c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	if c.rc != nil {
+		return c.rc.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *icmpConn) Close() error {
+	err := c.c.Close()
+	if c.rc != nil {
+		if rerr := c.rc.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+func (c *icmpConn) LocalAddr() net.Addr {
+	return c.c.LocalAddr()
+}
+
+// listen opens the ICMP endpoint described by opts and returns it together
+// with its IPv4 packet view.
+func listen(opts Options) (net.PacketConn, *ipv4.PacketConn, error) {
+	if opts.Port != 0 {
+		if opts.Network != "udp4" {
+			return nil, nil, errors.New("a local port can only be bound with the udp4 network")
+		}
+		// icmp.ListenPacket ignores the port, so bind the socket here.
+		c, err := listenDatagram(opts.Address, opts.Port)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c, ipv4.NewPacketConn(c), nil
+	}
+	if opts.RecordRoute {
+		if opts.Network != "ip4:icmp" {
+			return nil, nil, errors.New("recording the route requires the ip4:icmp network")
+		}
+		// icmp.PacketConn does not give access to the socket, which
+		// is needed to set the IP options.
+		c, err := net.ListenPacket(opts.Network, opts.Address)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := setRecordRoute(c); err != nil {
+			opts.logf("cannot set the record route option: %v", err)
+		}
+		return c, ipv4.NewPacketConn(c), nil
+	}
+	c, err := icmp.ListenPacket(opts.Network, opts.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, c.IPv4PacketConn(), nil
+}
+
+// listenRaw opens the raw socket through which Options.Header reads the
+// replies with their IPv4 header. Nothing is written to it.
+func listenRaw(opts Options) (*ipv4.RawConn, error) {
+	if opts.Network != "ip4:icmp" {
+		return nil, errors.New("reading the IPv4 header of the reply requires the ip4:icmp network")
+	}
+	c, err := net.ListenPacket(opts.Network, opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := ipv4.NewRawConn(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return rc, nil
+}
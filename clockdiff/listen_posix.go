@@ -0,0 +1,37 @@
+//go:build darwin || linux
+
+package clockdiff
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenDatagram opens a datagram-oriented ICMP socket bound to address and
+// port.
+func listenDatagram(address string, port int) (net.PacketConn, error) {
+	a, err := net.ResolveIPAddr("ip4", address)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.IPv4zero.To4()
+	if len(a.IP) != 0 {
+		if ip = a.IP.To4(); ip == nil {
+			return nil, net.InvalidAddrError("non-ipv4 address")
+		}
+	}
+	s, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, protocolICMP)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	sa := &syscall.SockaddrInet4{Port: port}
+	copy(sa.Addr[:], ip)
+	if err := syscall.Bind(s, sa); err != nil {
+		syscall.Close(s)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(s), "datagram-oriented icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
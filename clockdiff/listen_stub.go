@@ -0,0 +1,12 @@
+//go:build !darwin && !linux
+
+package clockdiff
+
+import (
+	"errors"
+	"net"
+)
+
+func listenDatagram(address string, port int) (net.PacketConn, error) {
+	return nil, errors.New("binding a local port is not supported on this platform")
+}
@@ -0,0 +1,132 @@
+package clockdiff
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// fakeConn is a packetConn that answers every request written to it with the
+// packets returned by reply, as a remote host would, without touching the
+// network. Reads block until a packet is queued, the read deadline passes or
+// the conn is closed.
+type fakeConn struct {
+	// reply returns the packets sent back for the request req. A nil
+	// reply answers nothing.
+	reply func(req []byte) [][]byte
+
+	// short, if true, makes WriteTo write one byte less than asked.
+	short bool
+
+	// onWrite, if not nil, is called after every write, with mu held.
+	onWrite func()
+
+	mu       sync.Mutex
+	queue    [][]byte
+	deadline time.Time
+	writes   int
+	closes   int
+	wake     chan struct{}
+	closed   chan struct{}
+}
+
+func newFakeConn(reply func(req []byte) [][]byte) *fakeConn {
+	return &fakeConn{reply: reply, wake: make(chan struct{}, 1), closed: make(chan struct{})}
+}
+
+func (c *fakeConn) WriteTo(b []byte, dst net.Addr) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	if c.onWrite != nil {
+		defer c.onWrite()
+	}
+	if c.short {
+		return len(b) - 1, nil
+	}
+	if c.reply != nil {
+		c.queue = append(c.queue, c.reply(append([]byte(nil), b...))...)
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+	return len(b), nil
+}
+
+func (c *fakeConn) ReadFrom(b []byte) (int, *ipv4.Header, *ipv4.ControlMessage, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		if len(c.queue) > 0 {
+			p := c.queue[0]
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+			return copy(b, p), nil, nil, &net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}, nil
+		}
+		deadline := c.deadline
+		c.mu.Unlock()
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			t := time.NewTimer(time.Until(deadline))
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-c.wake:
+		case <-timeout:
+			return 0, nil, nil, nil, os.ErrDeadlineExceeded
+		case <-c.closed:
+			return 0, nil, nil, nil, net.ErrClosed
+		}
+	}
+}
+
+func (c *fakeConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closes++
+	if c.closes == 1 {
+		close(c.closed)
+	}
+	return nil
+}
+
+func (c *fakeConn) LocalAddr() net.Addr {
+	return &net.IPAddr{IP: net.IPv4zero}
+}
+
+// timestampReply returns the reply to the timestamp request req of a host
+// whose clock is offset ahead of the local one.
+func timestampReply(req []byte, offset time.Duration) []byte {
+	ts, err := ParseTimestamp(req[4:])
+	if err != nil {
+		panic(err)
+	}
+	now := time.Now().Add(offset)
+	ms := uint32(now.Sub(now.Truncate(24*time.Hour)) / time.Millisecond)
+	ts.ReceiveTimestamp, ts.TransmitTimestamp = ms, ms
+	b, err := (&icmp.Message{Type: ipv4.ICMPTypeTimestampReply, Body: ts}).Marshal(nil)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// offsetReplies returns a fakeConn reply function answering as a host whose
+// clock is offset ahead of the local one.
+func offsetReplies(offset time.Duration) func([]byte) [][]byte {
+	return func(req []byte) [][]byte {
+		return [][]byte{timestampReply(req, offset)}
+	}
+}
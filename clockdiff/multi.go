@@ -0,0 +1,111 @@
+package clockdiff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// DefaultParallelism is the number of hosts ClockDiffMulti and EachHost probe
+// at the same time when Options.Parallelism is zero.
+const DefaultParallelism = 4
+
+// MultiResult is the outcome of ClockDiffMulti. Results holds the
+// measurements of the hosts that replied and Errors the errors of every
+// other host, both in the order of the hosts. A host given several times has
+// an entry for each.
+type MultiResult struct {
+	Results []Result
+	Errors  []HostError
+}
+
+// HostError is the error of a host of ClockDiffMulti that did not reply.
+type HostError struct {
+	Host string
+	Err  error
+}
+
+func (e HostError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Host, e.Err)
+}
+
+func (e HostError) Unwrap() error {
+	return e.Err
+}
+
+// OK reports whether at least one host replied.
+func (m MultiResult) OK() bool {
+	return len(m.Results) > 0
+}
+
+// Err returns the errors of all the hosts that did not reply joined
+// together, or nil if every host replied.
+func (m MultiResult) Err() error {
+	var errs []error
+	for _, err := range m.Errors {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// EachHost calls fn for every host, with its index in hosts, running at most
+// opts.Parallelism calls at the same time, and returns once all of them have
+// returned. Raw sockets see the replies to every probe, so each call gets a
+// copy of opts with its own ICMP identifier to tell them apart: the first
+// host keeps opts.ID, or one derived from the process ID if it is zero, and
+// the following hosts get the next identifiers, wrapping from 65535 to 1.
+func EachHost(hosts []string, opts Options, fn func(i int, host string, opts Options)) {
+	n := opts.Parallelism
+	if n <= 0 {
+		n = DefaultParallelism
+	}
+	base := opts.ID & 0xffff
+	if base == 0 {
+		base = os.Getpid()%0xffff + 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n && w < len(hosts); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				o := opts
+				o.ID = (base-1+i)%0xffff + 1
+				fn(i, hosts[i], o)
+			}
+		}()
+	}
+	for i := range hosts {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// ClockDiffMulti measures the clock difference of every host, probing
+// opts.Parallelism of them at the same time as EachHost does. A host that
+// fails does not stop the others.
+func ClockDiffMulti(ctx context.Context, hosts []string, opts Options) MultiResult {
+	results := make([]*Result, len(hosts))
+	errs := make([]error, len(hosts))
+	EachHost(hosts, opts, func(i int, host string, o Options) {
+		r, err := ClockDiffContext(ctx, host, o)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		results[i] = &r
+	})
+	var m MultiResult
+	for i, host := range hosts {
+		if results[i] != nil {
+			m.Results = append(m.Results, *results[i])
+		} else {
+			m.Errors = append(m.Errors, HostError{Host: host, Err: errs[i]})
+		}
+	}
+	return m
+}
@@ -0,0 +1,84 @@
+package clockdiff
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEachHost(t *testing.T) {
+	hosts := []string{"a", "b", "a", "c", "d", "e", "f", "g", "h", "i"}
+	var (
+		mu            sync.Mutex
+		running, peak int
+		seen          = make(map[int]string)
+		ids           = make(map[int]bool)
+	)
+	EachHost(hosts, Options{ID: 100, Parallelism: 3}, func(i int, host string, opts Options) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		seen[i] = host
+		ids[opts.ID] = true
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	if peak > 3 {
+		t.Errorf("%d hosts probed at the same time, want at most 3", peak)
+	}
+	for i, host := range hosts {
+		if seen[i] != host {
+			t.Errorf("host %d: fn called with %q, want %q", i, seen[i], host)
+		}
+	}
+	if len(ids) != len(hosts) {
+		t.Errorf("%d distinct IDs for %d hosts", len(ids), len(hosts))
+	}
+	for id := 100; id < 100+len(hosts); id++ {
+		if !ids[id] {
+			t.Errorf("ID %d not used; want the hosts to get 100 onwards", id)
+		}
+	}
+}
+
+func TestEachHostIDWraps(t *testing.T) {
+	var mu sync.Mutex
+	ids := make([]int, 3)
+	EachHost([]string{"a", "b", "c"}, Options{ID: 0xfffe}, func(i int, host string, opts Options) {
+		mu.Lock()
+		ids[i] = opts.ID
+		mu.Unlock()
+	})
+	if want := []int{0xfffe, 0xffff, 1}; ids[0] != want[0] || ids[1] != want[1] || ids[2] != want[2] {
+		t.Errorf("IDs = %v, want %v", ids, want)
+	}
+}
+
+func TestClockDiffMulti(t *testing.T) {
+	if err := CheckRawSocket(); err != nil {
+		t.Skip(err)
+	}
+	// ::1 has no IPv4 address, so it fails without a DNS lookup.
+	hosts := []string{"127.0.0.1", "::1", "127.0.0.1", "::1"}
+	m := ClockDiffMulti(context.Background(), hosts, Options{Timeout: time.Second})
+	if len(m.Results) != 2 {
+		t.Errorf("%d results, want 2: %v", len(m.Results), m.Err())
+	}
+	if len(m.Errors) != 2 {
+		t.Fatalf("%d errors, want 2: %v", len(m.Errors), m.Errors)
+	}
+	for _, err := range m.Errors {
+		if err.Host != "::1" || err.Err == nil {
+			t.Errorf("error %v, want one of ::1", err)
+		}
+	}
+	if !m.OK() || m.Err() == nil {
+		t.Errorf("OK() = %v, Err() = %v; want true and the errors of ::1", m.OK(), m.Err())
+	}
+}
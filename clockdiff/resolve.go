@@ -0,0 +1,99 @@
+package clockdiff
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// nameExists looks host up again through r, watching the DNS responses, to
+// tell a name that does not exist (NXDOMAIN) from one that exists without
+// address records (NODATA): net.Resolver returns the same error for both.
+// The name exists if any response succeeded, and does not if every one was
+// NXDOMAIN. ok is false when neither is certain, e.g. when no response could
+// be seen because the platform resolver does not use Resolver.Dial.
+func nameExists(ctx context.Context, r *net.Resolver, host string) (exists, ok bool) {
+	var (
+		mu                        sync.Mutex
+		responses, found, missing int
+	)
+	record := func(b []byte) {
+		var p dnsmessage.Parser
+		h, err := p.Start(b)
+		if err != nil || !h.Response {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		responses++
+		switch h.RCode {
+		case dnsmessage.RCodeSuccess:
+			found++
+		case dnsmessage.RCodeNameError:
+			missing++
+		}
+	}
+	dial := r.Dial
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	w := &net.Resolver{
+		PreferGo:     true,
+		StrictErrors: r.StrictErrors,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			c, err := dial(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			// Only datagram responses are watched: over a stream,
+			// a read need not hold a whole message.
+			if pc, ok := c.(net.PacketConn); ok {
+				return &watchedConn{Conn: c, pc: pc, record: record}, nil
+			}
+			return c, nil
+		},
+	}
+	w.LookupIP(ctx, "ip4", host)
+	mu.Lock()
+	defer mu.Unlock()
+	switch {
+	case found > 0:
+		return true, true
+	case responses > 0 && missing == responses:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// watchedConn is a datagram connection to a DNS server that passes every
+// message read to record. It implements net.PacketConn, so that net.Resolver
+// still treats it as a datagram connection.
+type watchedConn struct {
+	net.Conn
+	pc     net.PacketConn
+	record func([]byte)
+}
+
+func (c *watchedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(b[:n])
+	}
+	return n, err
+}
+
+func (c *watchedConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.pc.ReadFrom(b)
+	if n > 0 {
+		c.record(b[:n])
+	}
+	return n, addr, err
+}
+
+func (c *watchedConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.pc.WriteTo(b, addr)
+}
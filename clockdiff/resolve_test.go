@@ -0,0 +1,95 @@
+package clockdiff
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNS serves, on a loopback UDP socket, answers without records to every
+// query, with RCode NXDOMAIN for the names starting with "missing." and
+// success for the others. It returns a resolver using it.
+func fakeDNS(t *testing.T) *net.Resolver {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(b[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+			rcode := dnsmessage.RCodeSuccess
+			if strings.HasPrefix(q.Name.String(), "missing.") {
+				rcode = dnsmessage.RCodeNameError
+			}
+			resp := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true, RecursionAvailable: true, RCode: rcode})
+			resp.StartQuestions()
+			resp.Question(q)
+			msg, err := resp.Finish()
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(msg, addr)
+		}
+	}()
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", pc.LocalAddr().String())
+		},
+	}
+}
+
+func TestLookupIPv4NotFound(t *testing.T) {
+	r := fakeDNS(t)
+	tests := []struct {
+		host      string
+		want      error
+		wantNotOf error
+	}{
+		{"missing.example.", ErrNoSuchHost, ErrNoAddress},
+		// A name with other records only, e.g. MX, gets an empty
+		// successful answer (NODATA).
+		{"mx-only.example.", ErrNoAddress, ErrNoSuchHost},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := LookupIPv4(ctx, tt.host, Options{Resolver: r})
+		cancel()
+		if !errors.Is(err, ErrResolve) || !errors.Is(err, tt.want) || errors.Is(err, tt.wantNotOf) {
+			t.Errorf("LookupIPv4(%q) error = %v, want %v only", tt.host, err, tt.want)
+		}
+	}
+}
+
+func TestNameExistsUnseen(t *testing.T) {
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errors.New("no DNS server")
+		},
+	}
+	if _, ok := nameExists(context.Background(), r, "mx-only.example."); ok {
+		t.Error("nameExists is certain without any DNS response")
+	}
+}
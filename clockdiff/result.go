@@ -0,0 +1,116 @@
+package clockdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DescribeDelta spells out the sign convention of Result.Delta.
+func DescribeDelta(delta int64) string {
+	switch {
+	case delta > 0:
+		return fmt.Sprintf("remote clock is %dms behind local", delta)
+	case delta < 0:
+		return fmt.Sprintf("remote clock is %dms ahead of local", -delta)
+	default:
+		return "clocks agree"
+	}
+}
+
+// FormatMeasuredRTT formats Result.MeasuredRTT in milliseconds with
+// microsecond resolution.
+func FormatMeasuredRTT(d time.Duration) string {
+	return fmt.Sprintf("%.3f ms", float64(d.Microseconds())/1000)
+}
+
+// TextOptions varies the report written by Result.WriteText.
+type TextOptions struct {
+	// Name, if not empty, is printed after the IP address as its host
+	// name.
+	Name string
+
+	// Epoch prints the timestamps as milliseconds since the Unix epoch,
+	// together with the local receive time, instead of since midnight
+	// UTC.
+	Epoch bool
+
+	// Verbose adds the timestamps as times.
+	Verbose bool
+
+	// Delta, if not nil, is applied to the text of the delta, e.g. to
+	// color it.
+	Delta func(text string, delta int64) string
+}
+
+// WriteText writes the human readable report of r printed by goclockdiff to
+// out.
+func (r Result) WriteText(out io.Writer, o TextOptions) error {
+	w := new(tabwriter.Writer)
+	w.Init(out, 0, 4, 0, '\t', 0)
+	if o.Name != "" {
+		fmt.Fprintf(w, "Host:\t%s (%s)\n", r.IP, o.Name)
+	} else {
+		fmt.Fprintf(w, "Host:\t%s\n", r.IP)
+	}
+	fmt.Fprintf(w, "Reply from:\t%s\n", r.Peer)
+	if o.Epoch {
+		fmt.Fprintf(w, "ICMP timestamp:\tOriginate=%d Receive=%d Transmit=%d Local receive=%d\n", r.OriginTime.UnixMilli(), r.ReceiveTime.UnixMilli(), r.TransmitTime.UnixMilli(), r.ReceivedAt.UnixMilli())
+	} else {
+		fmt.Fprintf(w, "ICMP timestamp:\tOriginate=%d Receive=%d Transmit=%d\n", r.OriginTimestamp, r.ReceiveTimestamp, r.TransmitTimestamp)
+	}
+	if o.Verbose {
+		const layout = "2006-01-02 15:04:05.000Z07:00"
+		fmt.Fprintf(w, "ICMP timestamp time:\tOriginate=%s Receive=%s Transmit=%s\n", r.OriginTime.Format(layout), r.ReceiveTime.Format(layout), r.TransmitTime.Format(layout))
+	}
+	fmt.Fprintf(w, "ICMP timestamp RTT:\ttsrtt=%d\n", r.RTT)
+	fmt.Fprintf(w, "Measured RTT:\t%s\n", FormatMeasuredRTT(r.MeasuredRTT))
+	delta := fmt.Sprintf("delta=%d (%s)", r.Delta, DescribeDelta(r.Delta))
+	if o.Delta != nil {
+		delta = o.Delta(delta, r.Delta)
+	}
+	fmt.Fprintf(w, "Time difference:\t%s\n", delta)
+	for _, d := range r.Duplicates {
+		fmt.Fprintf(w, "Duplicate reply:\tfrom %s tsrtt=%d delta=%d DUP!\n", d.Peer, d.RTT, d.Delta)
+	}
+	if r.ReplyTTL > 0 {
+		fmt.Fprintf(w, "Reply TTL:\tttl=%d\n", r.ReplyTTL)
+	}
+	if r.Retries > 0 {
+		fmt.Fprintf(w, "Retries:\t%d\n", r.Retries)
+	}
+	if r.Header != nil {
+		fmt.Fprintf(w, "IPv4 header:\t%s\n", r.Header)
+	}
+	if len(r.Route) > 0 {
+		hops := make([]string, len(r.Route))
+		for i, ip := range r.Route {
+			hops[i] = ip.String()
+		}
+		fmt.Fprintf(w, "Route:\t%s\n", strings.Join(hops, " -> "))
+	}
+	return w.Flush()
+}
+
+// String returns the human readable report of r printed by goclockdiff -n.
+func (r Result) String() string {
+	var b strings.Builder
+	r.WriteText(&b, TextOptions{})
+	return b.String()
+}
+
+// MarshalJSON encodes r with the field names given by its struct tags. The
+// resolved IP is encoded as a string, the times in RFC 3339 format and
+// MeasuredRTT as "measured_rtt_us".
+func (r Result) MarshalJSON() ([]byte, error) {
+	// result has the fields of Result but not its methods, so that
+	// json.Marshal does not call back into MarshalJSON.
+	type result Result
+	return json.Marshal(struct {
+		result
+		MeasuredRTT int64 `json:"measured_rtt_us"`
+	}{result(r), r.MeasuredRTT.Microseconds()})
+}
@@ -0,0 +1,129 @@
+package clockdiff
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeltaSign(t *testing.T) {
+	tests := []struct {
+		name     string
+		offset   int64
+		delta    int64
+		describe string
+	}{
+		{"remote ahead", 100, -100, "remote clock is 100ms ahead of local"},
+		{"remote behind", -100, 100, "remote clock is 100ms behind local"},
+		{"clocks agree", 0, 0, "clocks agree"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t1, t2, t3, t4 := syntheticTimestamps(1000, 5, 5, 1, tt.offset)
+			_, delta := diff(t1, t2, t3, t4)
+			if delta != tt.delta {
+				t.Errorf("delta = %d, want %d", delta, tt.delta)
+			}
+			if got := DescribeDelta(delta); got != tt.describe {
+				t.Errorf("DescribeDelta(%d) = %q, want %q", delta, got, tt.describe)
+			}
+		})
+	}
+}
+
+func testResult() Result {
+	sent := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return Result{
+		Host:              "example.com",
+		IP:                net.IPv4(192, 0, 2, 1),
+		Peer:              "192.0.2.1",
+		OriginTimestamp:   43200000,
+		ReceiveTimestamp:  43200010,
+		TransmitTimestamp: 43200011,
+		RTT:               4,
+		Delta:             -8,
+		MeasuredRTT:       4567 * time.Microsecond,
+		Seq:               3,
+		ForwardDelay:      10,
+		ReturnDelay:       -6,
+		ReplyTTL:          64,
+		SentAt:            sent,
+		ReceivedAt:        sent.Add(5 * time.Millisecond),
+		OriginTime:        sent,
+		ReceiveTime:       sent.Add(10 * time.Millisecond),
+		TransmitTime:      sent.Add(11 * time.Millisecond),
+	}
+}
+
+func TestResultString(t *testing.T) {
+	want := "" +
+		"Host:\t\t\t\t192.0.2.1\n" +
+		"Reply from:\t\t\t192.0.2.1\n" +
+		"ICMP timestamp:\t\tOriginate=43200000 Receive=43200010 Transmit=43200011\n" +
+		"ICMP timestamp RTT:\ttsrtt=4\n" +
+		"Measured RTT:\t\t4.567 ms\n" +
+		"Time difference:\tdelta=-8 (remote clock is 8ms ahead of local)\n" +
+		"Reply TTL:\t\t\tttl=64\n"
+	if got := testResult().String(); got != want {
+		t.Errorf("String() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestResultWriteText(t *testing.T) {
+	var b strings.Builder
+	err := testResult().WriteText(&b, TextOptions{
+		Name:    "example.com",
+		Epoch:   true,
+		Verbose: true,
+		Delta:   func(text string, delta int64) string { return "<" + text + ">" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"Host:\t\t\t\t192.0.2.1 (example.com)\n",
+		"ICMP timestamp:\t\tOriginate=1709294400000 Receive=1709294400010 Transmit=1709294400011 Local receive=1709294400005\n",
+		"ICMP timestamp time:Originate=2024-03-01 12:00:00.000Z Receive=2024-03-01 12:00:00.010Z Transmit=2024-03-01 12:00:00.011Z\n",
+		"Time difference:\t<delta=-8 (remote clock is 8ms ahead of local)>\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("WriteText output lacks %q:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestResultMarshalJSON(t *testing.T) {
+	b, err := json.Marshal(testResult())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"host":            "example.com",
+		"resolved_ip":     "192.0.2.1",
+		"peer":            "192.0.2.1",
+		"origin_ms":       43200000.0,
+		"rtt_ms":          4.0,
+		"delta_ms":        -8.0,
+		"measured_rtt_us": 4567.0,
+		"seq":             3.0,
+		"forward_ms":      10.0,
+		"return_ms":       -6.0,
+		"reply_ttl":       64.0,
+		"sent_at":         "2024-03-01T12:00:00Z",
+		"received_at":     "2024-03-01T12:00:00.005Z",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+	if _, ok := got["MeasuredRTT"]; ok {
+		t.Error("MeasuredRTT is encoded under its Go name")
+	}
+}
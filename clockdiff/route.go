@@ -0,0 +1,62 @@
+package clockdiff
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// ipOptRecordRoute is the type of the IPv4 record route option, which has
+// room for recordRouteSlots addresses in the 40 bytes of IPv4 options.
+const (
+	ipOptRecordRoute = 7
+	recordRouteSlots = 9
+)
+
+// recordRouteOption returns the IPv4 options requesting the route to be
+// recorded, padded with an end of options byte.
+func recordRouteOption() []byte {
+	b := make([]byte, 40)
+	b[0] = ipOptRecordRoute
+	b[1] = 3 + 4*recordRouteSlots
+	b[2] = 4 // the first slot
+	return b
+}
+
+// recordedRoute returns the addresses recorded in the record route option of
+// h, or nil if it has none.
+func recordedRoute(h *ipv4.Header) []net.IP {
+	if h == nil {
+		return nil
+	}
+	b := h.Options
+	for i := 0; i < len(b); {
+		switch b[i] {
+		case 0: // end of options
+			return nil
+		case 1: // no operation
+			i++
+			continue
+		}
+		if i+1 >= len(b) || b[i+1] < 2 || i+int(b[i+1]) > len(b) {
+			return nil
+		}
+		n := int(b[i+1])
+		if b[i] == ipOptRecordRoute && n >= 3 {
+			opt := b[i : i+n]
+			// The pointer is one past the last recorded address,
+			// counting from 1, and at most one past the option.
+			end := int(opt[2]) - 1
+			if end > n {
+				end = n
+			}
+			var route []net.IP
+			for j := 3; j+4 <= end; j += 4 {
+				route = append(route, net.IPv4(opt[j], opt[j+1], opt[j+2], opt[j+3]))
+			}
+			return route
+		}
+		i += n
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build darwin || linux
+
+package clockdiff
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// setRecordRoute makes c send its packets with the record route option.
+func setRecordRoute(c net.PacketConn) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return errors.New("connection does not support socket options")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	opt := recordRouteOption()
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptString(int(fd), syscall.IPPROTO_IP, syscall.IP_OPTIONS, string(opt))
+	}); err != nil {
+		return err
+	}
+	return serr
+}
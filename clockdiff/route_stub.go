@@ -0,0 +1,12 @@
+//go:build !darwin && !linux
+
+package clockdiff
+
+import (
+	"errors"
+	"net"
+)
+
+func setRecordRoute(c net.PacketConn) error {
+	return errors.New("IPv4 options are not supported on this platform")
+}
@@ -0,0 +1,60 @@
+package clockdiff
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+// rrOption returns a record route option with room for slots addresses,
+// the given pointer and the addresses recorded so far.
+func rrOption(slots int, ptr byte, route ...net.IP) []byte {
+	b := make([]byte, 3+4*slots)
+	b[0], b[1], b[2] = ipOptRecordRoute, byte(len(b)), ptr
+	for i, ip := range route {
+		copy(b[3+4*i:], ip.To4())
+	}
+	return b
+}
+
+func TestRecordedRoute(t *testing.T) {
+	a, b := net.IPv4(192, 0, 2, 1), net.IPv4(198, 51, 100, 2)
+	full := make([]net.IP, recordRouteSlots)
+	for i := range full {
+		full[i] = net.IPv4(10, 0, 0, byte(i+1))
+	}
+	tests := []struct {
+		name    string
+		options []byte
+		want    []net.IP
+	}{
+		{"no options", nil, nil},
+		{"request", recordRouteOption(), nil},
+		{"empty", rrOption(9, 4), nil},
+		{"nop before", append([]byte{1}, rrOption(2, 8, a)...), []net.IP{a}},
+		{"partly filled", append(rrOption(9, 12, a, b), 0), []net.IP{a, b}},
+		{"full", rrOption(recordRouteSlots, 4+4*recordRouteSlots, full...), full},
+		{"pointer past the end", rrOption(2, 200, a, b), []net.IP{a, b}},
+		{"end of options before", append([]byte{0}, rrOption(2, 8, a)...), nil},
+		{"truncated length", []byte{1, ipOptRecordRoute}, nil},
+		{"length beyond the options", []byte{ipOptRecordRoute, 11, 8, 192, 0, 2}, nil},
+		{"other option first", append([]byte{0x44, 4, 5, 0}, rrOption(2, 8, a)...), []net.IP{a}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := recordedRoute(&ipv4.Header{Options: tt.options})
+			if len(got) != len(tt.want) {
+				t.Fatalf("recordedRoute = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("hop %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+	if got := recordedRoute(nil); got != nil {
+		t.Errorf("recordedRoute(nil) = %v, want nil", got)
+	}
+}
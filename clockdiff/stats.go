@@ -0,0 +1,163 @@
+package clockdiff
+
+import (
+	"math"
+	"sort"
+)
+
+// Summary describes a series of values in milliseconds.
+type Summary struct {
+	Min, Avg, Max, Mdev, Median float64
+}
+
+// Stats summarizes the RTT and Delta of a series of results. Count is the
+// number of results; the summaries are zero when it is 0.
+//
+// Jitter is the mean absolute difference between the RTTs of consecutive
+// results. It is undefined, and zero, when Count is less than 2.
+//
+// DeltaCI is the half-width of the 95% confidence interval of the mean
+// delta, computed from its standard error with Student's t-distribution. It
+// only accounts for the scatter of the probes: an asymmetric path biases
+// every delta alike and is not reflected. It is undefined, and zero, when
+// Count is less than MinConfidenceCount.
+type Stats struct {
+	Count   int
+	RTT     Summary
+	Delta   Summary
+	Jitter  float64
+	DeltaCI float64
+}
+
+// Best returns the result with the lowest RTT, the one least affected by
+// queueing and so giving the most accurate delta. It returns false if
+// results is empty.
+func Best(results []Result) (Result, bool) {
+	if len(results) == 0 {
+		return Result{}, false
+	}
+	best := results[0]
+	for _, r := range results[1:] {
+		if r.RTT < best.RTT {
+			best = r
+		}
+	}
+	return best, true
+}
+
+// MinConfidenceCount is the number of results from which Stats.DeltaCI is
+// computed.
+const MinConfidenceCount = 3
+
+// tQuantiles are the two-sided 95% quantiles of Student's t-distribution
+// for 1 to 30 degrees of freedom. Above that the normal distribution's 1.96
+// is used.
+var tQuantiles = [...]float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// confidence returns the half-width of the 95% confidence interval of the
+// mean of xs.
+func confidence(xs []float64, mean float64) float64 {
+	var ss float64
+	for _, x := range xs {
+		ss += (x - mean) * (x - mean)
+	}
+	n := len(xs)
+	sem := math.Sqrt(ss/float64(n-1)) / math.Sqrt(float64(n))
+	t := 1.96
+	if n-1 <= len(tQuantiles) {
+		t = tQuantiles[n-2]
+	}
+	return t * sem
+}
+
+// Summarize computes statistics over results.
+func Summarize(results []Result) Stats {
+	st := Stats{Count: len(results)}
+	if len(results) == 0 {
+		return st
+	}
+	rtts := make([]float64, len(results))
+	deltas := make([]float64, len(results))
+	for i, r := range results {
+		rtts[i] = float64(r.RTT)
+		deltas[i] = float64(r.Delta)
+	}
+	st.RTT = summarize(rtts)
+	st.Delta = summarize(deltas)
+	if len(rtts) > 1 {
+		var sum float64
+		for i := 1; i < len(rtts); i++ {
+			sum += math.Abs(rtts[i] - rtts[i-1])
+		}
+		st.Jitter = sum / float64(len(rtts)-1)
+	}
+	if len(deltas) >= MinConfidenceCount {
+		st.DeltaCI = confidence(deltas, st.Delta.Avg)
+	}
+	return st
+}
+
+// Drift estimates the rate at which the delta of results changes, in parts
+// per million, by a least-squares fit of Delta over SentAt. A positive drift
+// means that the remote clock runs slower than the local one. It returns
+// false if there are fewer than two results or they were all sent at the
+// same time.
+//
+// The deltas have a resolution of one millisecond, so the estimate only
+// becomes meaningful over runs long enough for the clocks to drift apart by
+// several milliseconds.
+func Drift(results []Result) (float64, bool) {
+	if len(results) < 2 {
+		return 0, false
+	}
+	start := results[0].SentAt
+	var sx, sy float64
+	xs := make([]float64, len(results))
+	for i, r := range results {
+		xs[i] = r.SentAt.Sub(start).Seconds()
+		sx += xs[i]
+		sy += float64(r.Delta)
+	}
+	n := float64(len(results))
+	mx, my := sx/n, sy/n
+	var sxx, sxy float64
+	for i, r := range results {
+		dx := xs[i] - mx
+		sxx += dx * dx
+		sxy += dx * (float64(r.Delta) - my)
+	}
+	if sxx == 0 {
+		return 0, false
+	}
+	// The slope is in milliseconds per second, and 1 ms/s is 1000 ppm.
+	return sxy / sxx * 1000, true
+}
+
+func summarize(xs []float64) Summary {
+	s := Summary{Min: xs[0], Max: xs[0]}
+	var sum, sumSq float64
+	for _, x := range xs {
+		s.Min = math.Min(s.Min, x)
+		s.Max = math.Max(s.Max, x)
+		sum += x
+		sumSq += x * x
+	}
+	n := float64(len(xs))
+	s.Avg = sum / n
+	s.Mdev = math.Sqrt(math.Max(sumSq/n-s.Avg*s.Avg, 0))
+
+	sorted := make([]float64, len(xs))
+	copy(sorted, xs)
+	sort.Float64s(sorted)
+	m := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		s.Median = (sorted[m-1] + sorted[m]) / 2
+	} else {
+		s.Median = sorted[m]
+	}
+	return s
+}
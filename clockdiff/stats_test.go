@@ -0,0 +1,168 @@
+package clockdiff
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// series returns results sent every step from a fixed time, with the
+// given deltas.
+func series(step time.Duration, deltas ...int64) []Result {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	results := make([]Result, len(deltas))
+	for i, d := range deltas {
+		results[i] = Result{SentAt: start.Add(time.Duration(i) * step), Delta: d}
+	}
+	return results
+}
+
+func TestDrift(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []Result
+		ppm     float64
+		ok      bool
+	}{
+		// The delta grows by 1ms every 10s: the local clock gains
+		// 0.1ms/s, 100 ppm, on the remote one, which runs slower.
+		{"remote slower", series(10*time.Second, 0, 1, 2, 3, 4), 100, true},
+		{"remote faster", series(100*time.Second, 10, 8, 6, 4), -20, true},
+		{"flat", series(time.Second, 5, 5, 5), 0, true},
+		{"no results", nil, 0, false},
+		{"one result", series(time.Second, 5), 0, false},
+		{"same send time", series(0, 1, 2, 3), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ppm, ok := Drift(tt.results)
+			if ok != tt.ok || math.Abs(ppm-tt.ppm) > 1e-9 {
+				t.Errorf("Drift = %v, %v; want %v, %v", ppm, ok, tt.ppm, tt.ok)
+			}
+		})
+	}
+}
+
+// seq returns the n values 0 to n-1.
+func seq(n int) []float64 {
+	xs := make([]float64, n)
+	for i := range xs {
+		xs[i] = float64(i)
+	}
+	return xs
+}
+
+func TestConfidence(t *testing.T) {
+	tests := []struct {
+		name string
+		xs   []float64
+		want float64
+	}{
+		// sd 1, sem 1/√3 and t 4.303 for 2 degrees of freedom.
+		{"n=3", []float64{1, 2, 3}, 2.4843},
+		// sd √(2480/30), sem sd/√31 and t 2.042 for 30 degrees of
+		// freedom, the last of the table.
+		{"n=31", seq(31), 3.3346},
+		// sd √88, sem sd/√32 and the normal 1.96 above 30 degrees of
+		// freedom.
+		{"n=32", seq(32), 3.2503},
+	}
+	for _, tt := range tests {
+		var sum float64
+		for _, x := range tt.xs {
+			sum += x
+		}
+		got := confidence(tt.xs, sum/float64(len(tt.xs)))
+		if math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("%s: confidence = %.4f, want %.4f", tt.name, got, tt.want)
+		}
+	}
+}
+
+// results returns results with the given RTTs and deltas.
+func results(rtts, deltas []int64) []Result {
+	rs := make([]Result, len(rtts))
+	for i := range rtts {
+		rs[i] = Result{RTT: rtts[i], Delta: deltas[i]}
+	}
+	return rs
+}
+
+// near reports whether the summaries are equal to within 1e-4.
+func near(a, b Summary) bool {
+	for _, d := range []float64{a.Min - b.Min, a.Avg - b.Avg, a.Max - b.Max, a.Mdev - b.Mdev, a.Median - b.Median} {
+		if math.Abs(d) > 1e-4 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name       string
+		results    []Result
+		rtt, delta Summary
+	}{
+		{"no results", nil, Summary{}, Summary{}},
+		{"one result", results([]int64{5}, []int64{-3}), Summary{5, 5, 5, 0, 5}, Summary{-3, -3, -3, 0, -3}},
+		{
+			"odd count",
+			results([]int64{3, 1, 2}, []int64{10, -4, 2}),
+			Summary{Min: 1, Avg: 2, Max: 3, Mdev: 0.8165, Median: 2},
+			Summary{Min: -4, Avg: 2.6667, Max: 10, Mdev: 5.7349, Median: 2},
+		},
+		{
+			"even count",
+			results([]int64{4, 1, 3, 2}, []int64{0, 0, 0, 0}),
+			Summary{Min: 1, Avg: 2.5, Max: 4, Mdev: 1.1180, Median: 2.5},
+			Summary{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := Summarize(tt.results)
+			if st.Count != len(tt.results) {
+				t.Errorf("Count = %d, want %d", st.Count, len(tt.results))
+			}
+			if !near(st.RTT, tt.rtt) {
+				t.Errorf("RTT = %+v, want %+v", st.RTT, tt.rtt)
+			}
+			if !near(st.Delta, tt.delta) {
+				t.Errorf("Delta = %+v, want %+v", st.Delta, tt.delta)
+			}
+		})
+	}
+}
+
+func TestSummarizeDeltaCI(t *testing.T) {
+	if st := Summarize(results([]int64{1, 1}, []int64{10, -4})); st.DeltaCI != 0 {
+		t.Errorf("DeltaCI of 2 results = %v, want 0", st.DeltaCI)
+	}
+	// sd √(98.667/2), sem sd/√3 and t 4.303.
+	if st := Summarize(results([]int64{1, 1, 1}, []int64{10, -4, 2})); math.Abs(st.DeltaCI-17.4494) > 1e-4 {
+		t.Errorf("DeltaCI = %.4f, want 17.4494", st.DeltaCI)
+	}
+}
+
+func TestSummarizeJitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		rtts   []int64
+		jitter float64
+	}{
+		{"no results", nil, 0},
+		{"one result", []int64{5}, 0},
+		{"steady", []int64{5, 5, 5}, 0},
+		// |1-3| and |2-1|.
+		{"odd count", []int64{3, 1, 2}, 1.5},
+		// |1-4|, |3-1| and |2-3|: the order matters, not the spread.
+		{"even count", []int64{4, 1, 3, 2}, 2},
+	}
+	for _, tt := range tests {
+		st := Summarize(results(tt.rtts, make([]int64, len(tt.rtts))))
+		if math.Abs(st.Jitter-tt.jitter) > 1e-9 {
+			t.Errorf("%s: Jitter = %v, want %v", tt.name, st.Jitter, tt.jitter)
+		}
+	}
+}
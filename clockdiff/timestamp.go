@@ -0,0 +1,134 @@
+package clockdiff
+
+import (
+	"fmt"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Timestamp is the body of an ICMP timestamp or timestamp reply message. It
+// implements icmp.MessageBody for ICMPv4 only: ICMPv6 has no timestamp
+// message.
+type Timestamp struct {
+	ID                int
+	Seq               int
+	OriginTimestamp   uint32
+	ReceiveTimestamp  uint32
+	TransmitTimestamp uint32
+
+	// NonStandard is set when the high-order bit of the receive or
+	// transmit timestamp is set. RFC 792 then allows any host-defined
+	// format, so the values are not milliseconds since midnight UTC.
+	NonStandard bool
+
+	// Padding is the number of zero bytes appended to the marshalled
+	// body.
+	Padding int
+}
+
+const marshalledTimestampLen = 16
+
+const nonStandardTimestamp = 1 << 31
+
+// Len returns the length of the marshalled body, or 0 if proto is not
+// ICMPv4.
+func (t *Timestamp) Len(proto int) int {
+	if t == nil || proto != protocolICMP {
+		return 0
+	}
+	return marshalledTimestampLen + t.Padding
+}
+
+// Marshal returns the body in wire format. It fails if proto is not ICMPv4.
+func (t *Timestamp) Marshal(proto int) ([]byte, error) {
+	if proto != protocolICMP {
+		return nil, fmt.Errorf("timestamp message is not defined for protocol %d, only for ICMPv4", proto)
+	}
+	if t.Padding < 0 {
+		return nil, fmt.Errorf("negative padding %d", t.Padding)
+	}
+	b := make([]byte, t.Len(proto))
+	if _, err := t.MarshalTo(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// MarshalTo writes the body to b, which must be at least Len bytes long, and
+// returns the number of bytes written. Unlike Marshal it does not allocate.
+func (t *Timestamp) MarshalTo(b []byte) (int, error) {
+	if t.Padding < 0 {
+		return 0, fmt.Errorf("negative padding %d", t.Padding)
+	}
+	n := marshalledTimestampLen + t.Padding
+	if len(b) < n {
+		return 0, fmt.Errorf("buffer length %d shorter than %d", len(b), n)
+	}
+	b[0], b[1] = byte(t.ID>>8), byte(t.ID)
+	b[2], b[3] = byte(t.Seq>>8), byte(t.Seq)
+
+	unparseInt := func(i uint32) (byte, byte, byte, byte) {
+		return byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i)
+	}
+	b[4], b[5], b[6], b[7] = unparseInt(t.OriginTimestamp)
+	b[8], b[9], b[10], b[11] = unparseInt(t.ReceiveTimestamp)
+	b[12], b[13], b[14], b[15] = unparseInt(t.TransmitTimestamp)
+	for i := marshalledTimestampLen; i < n; i++ {
+		b[i] = 0
+	}
+	return n, nil
+}
+
+// checksum computes the Internet checksum of b.
+func checksum(b []byte) uint16 {
+	var s uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s>>16 != 0 {
+		s = s&0xffff + s>>16
+	}
+	return ^uint16(s)
+}
+
+// marshalRequest marshals the timestamp request message with body t into b,
+// growing b if needed, and returns the message.
+func marshalRequest(b []byte, t *Timestamp) ([]byte, error) {
+	if t.Padding < 0 {
+		return nil, fmt.Errorf("negative padding %d", t.Padding)
+	}
+	n := 4 + t.Len(protocolICMP)
+	if cap(b) < n {
+		b = make([]byte, n)
+	}
+	b = b[:n]
+	b[0], b[1], b[2], b[3] = byte(ipv4.ICMPTypeTimestamp), 0, 0, 0
+	if _, err := t.MarshalTo(b[4:]); err != nil {
+		return nil, err
+	}
+	s := checksum(b)
+	b[2], b[3] = byte(s>>8), byte(s)
+	return b, nil
+}
+
+// ParseTimestamp parses the body of an ICMP timestamp message. Bytes after
+// the first 16, such as padding, are ignored.
+func ParseTimestamp(b []byte) (*Timestamp, error) {
+	bodyLen := len(b)
+	if bodyLen < marshalledTimestampLen {
+		return nil, fmt.Errorf("timestamp body length %d shorter than %d", bodyLen, marshalledTimestampLen)
+	}
+	p := &Timestamp{ID: int(b[0])<<8 | int(b[1]), Seq: int(b[2])<<8 | int(b[3])}
+
+	parseInt := func(start int) uint32 {
+		return uint32(b[start])<<24 | uint32(b[start+1])<<16 | uint32(b[start+2])<<8 | uint32(b[start+3])
+	}
+	p.OriginTimestamp = parseInt(4)
+	p.ReceiveTimestamp = parseInt(8)
+	p.TransmitTimestamp = parseInt(12)
+	p.NonStandard = p.ReceiveTimestamp&nonStandardTimestamp != 0 || p.TransmitTimestamp&nonStandardTimestamp != 0
+	return p, nil
+}
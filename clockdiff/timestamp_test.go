@@ -0,0 +1,214 @@
+package clockdiff
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestTimestampRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Timestamp
+	}{
+		{"zero", Timestamp{}},
+		{"max timestamps", Timestamp{OriginTimestamp: math.MaxUint32, ReceiveTimestamp: math.MaxUint32, TransmitTimestamp: math.MaxUint32}},
+		{"max id and seq", Timestamp{ID: 0xffff, Seq: 0xffff}},
+		{"min id and seq", Timestamp{ID: 1, Seq: 0}},
+		{"end of day", Timestamp{ID: 0x1234, Seq: 7, OriginTimestamp: msPerDay - 1, ReceiveTimestamp: 0, TransmitTimestamp: 1}},
+		{"padding", Timestamp{ID: 1, Seq: 1, OriginTimestamp: 1, ReceiveTimestamp: 2, TransmitTimestamp: 3, Padding: 40}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.in.Marshal(protocolICMP)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if len(b) != tt.in.Len(protocolICMP) {
+				t.Errorf("Marshal returned %d bytes, Len %d", len(b), tt.in.Len(protocolICMP))
+			}
+			got, err := ParseTimestamp(b)
+			if err != nil {
+				t.Fatalf("ParseTimestamp: %v", err)
+			}
+			want := tt.in
+			// Padding is not part of the parsed body, and the
+			// high-order bit marks non-standard timestamps.
+			want.Padding = 0
+			want.NonStandard = want.ReceiveTimestamp&nonStandardTimestamp != 0 || want.TransmitTimestamp&nonStandardTimestamp != 0
+			if *got != want {
+				t.Errorf("ParseTimestamp(Marshal(%+v)) = %+v, want %+v", tt.in, *got, want)
+			}
+		})
+	}
+}
+
+func TestTimestampMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    Timestamp
+		proto int
+		want  string
+	}{
+		{"negative padding", Timestamp{Padding: -3}, protocolICMP, "negative padding"},
+		{"very negative padding", Timestamp{Padding: -100}, protocolICMP, "negative padding"},
+		{"icmpv6", Timestamp{}, 58, "only for ICMPv4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.in.Marshal(tt.proto); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Marshal(%d) error = %v, want %q", tt.proto, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimestampMarshalToShortBuffer(t *testing.T) {
+	ts := Timestamp{Padding: 4}
+	if _, err := ts.MarshalTo(make([]byte, marshalledTimestampLen+3)); err == nil {
+		t.Error("MarshalTo into a short buffer succeeded")
+	}
+	if _, err := ts.MarshalTo(make([]byte, 1)); err == nil {
+		t.Error("MarshalTo into a 1-byte buffer succeeded")
+	}
+}
+
+func TestTimestampMarshalToZeroesPadding(t *testing.T) {
+	b := make([]byte, marshalledTimestampLen+8)
+	for i := range b {
+		b[i] = 0xff
+	}
+	ts := Timestamp{Padding: 8}
+	n, err := ts.MarshalTo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range b[:n] {
+		if c != 0 {
+			t.Fatalf("byte %d = %#x, want 0", i, c)
+		}
+	}
+}
+
+func TestParseTimestampShortBody(t *testing.T) {
+	if _, err := ParseTimestamp(make([]byte, marshalledTimestampLen-1)); err == nil {
+		t.Error("ParseTimestamp of a 15-byte body succeeded")
+	}
+}
+
+func TestParseTimestampNonStandard(t *testing.T) {
+	tests := []struct {
+		receive, transmit uint32
+		want              bool
+	}{
+		{0, 0, false},
+		{msPerDay - 1, msPerDay - 1, false},
+		{nonStandardTimestamp, 0, true},
+		{0, nonStandardTimestamp | 5, true},
+	}
+	for _, tt := range tests {
+		b, err := (&Timestamp{ReceiveTimestamp: tt.receive, TransmitTimestamp: tt.transmit}).Marshal(protocolICMP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParseTimestamp(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.NonStandard != tt.want {
+			t.Errorf("receive=%#x transmit=%#x: NonStandard = %v, want %v", tt.receive, tt.transmit, got.NonStandard, tt.want)
+		}
+	}
+}
+
+func TestMarshalRequestSeqRoundTrip(t *testing.T) {
+	var b []byte
+	for seq := 0; seq <= 0xffff; seq++ {
+		var err error
+		b, err = marshalRequest(b, &Timestamp{ID: 0x4242, Seq: seq})
+		if err != nil {
+			t.Fatalf("seq %d: %v", seq, err)
+		}
+		if s := checksum(b); s != 0 {
+			t.Fatalf("seq %d: checksum of the request = %#x, want 0", seq, s)
+		}
+		got, err := ParseTimestamp(b[4:])
+		if err != nil {
+			t.Fatalf("seq %d: %v", seq, err)
+		}
+		if got.ID != 0x4242 || got.Seq != seq {
+			t.Fatalf("seq %d: parsed id=%#x seq=%d", seq, got.ID, got.Seq)
+		}
+	}
+}
+
+func TestParseTimestampLength(t *testing.T) {
+	for n := 0; n < marshalledTimestampLen; n++ {
+		if _, err := ParseTimestamp(make([]byte, n)); err == nil {
+			t.Errorf("ParseTimestamp of a %d-byte body succeeded", n)
+		}
+	}
+	// Longer bodies, e.g. with padding, are accepted and the extra bytes
+	// ignored.
+	for _, n := range []int{marshalledTimestampLen, marshalledTimestampLen + 1, marshalledTimestampLen + 40} {
+		b := make([]byte, n)
+		b[1], b[3] = 7, 9
+		for i := marshalledTimestampLen; i < n; i++ {
+			b[i] = 0xff
+		}
+		got, err := ParseTimestamp(b)
+		if err != nil {
+			t.Errorf("ParseTimestamp of a %d-byte body: %v", n, err)
+			continue
+		}
+		if got.ID != 7 || got.Seq != 9 {
+			t.Errorf("ParseTimestamp of a %d-byte body: id=%d seq=%d, want 7 and 9", n, got.ID, got.Seq)
+		}
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	ts := Timestamp{ID: 0x4242, Seq: 1, OriginTimestamp: 43200000}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ts.Marshal(protocolICMP); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalTo(b *testing.B) {
+	ts := Timestamp{ID: 0x4242, Seq: 1, OriginTimestamp: 43200000}
+	buf := make([]byte, ts.Len(protocolICMP))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ts.MarshalTo(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseTimestamp(b *testing.B) {
+	buf, err := (&Timestamp{ID: 0x4242, Seq: 1, OriginTimestamp: 43200000}).Marshal(protocolICMP)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseTimestamp(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalRequest(b *testing.B) {
+	ts := Timestamp{ID: 0x4242, Seq: 1, OriginTimestamp: 43200000}
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if buf, err = marshalRequest(buf, &ts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "os"
+
+// ANSI escape sequences used by colorDelta.
+const (
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorReset = "\x1b[0m"
+)
+
+// colorSkewThreshold is the absolute delta, in milliseconds, above which
+// colorDelta highlights it as a large skew.
+const colorSkewThreshold = 100
+
+// colorDelta returns s, the text of the delta d in milliseconds, in red if d
+// is a large skew and in green otherwise. It returns s unchanged if color is
+// false.
+func colorDelta(s string, d float64, color bool) string {
+	if !color {
+		return s
+	}
+	if d > colorSkewThreshold || d < -colorSkewThreshold {
+		return colorRed + s + colorReset
+	}
+	return colorGreen + s + colorReset
+}
+
+// useColor reports whether the text output should be colored: only if
+// stdout is a terminal, and neither -no-color nor the NO_COLOR environment
+// variable (https://no-color.org) turns it off.
+func useColor(noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -1,185 +1,599 @@
 package main
 
 import (
-	"errors"
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
-	"math"
+	"io"
+	"log"
 	"net"
 	"os"
-	"text/tabwriter"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
-	"golang.org/x/net/icmp"
-	"golang.org/x/net/internal/iana"
-	"golang.org/x/net/internal/nettest"
-	"golang.org/x/net/ipv4"
+	"github.com/higebu/goclockdiff/clockdiff"
+	"golang.org/x/time/rate"
 )
 
-func getAddr(host string, c *icmp.PacketConn, protocol int) (net.Addr, error) {
-	ips, err := net.LookupIP(host)
-	if err != nil {
-		return nil, err
-	}
-	netaddr := func(ip net.IP) (net.Addr, error) {
-		switch c.LocalAddr().(type) {
-		case *net.UDPAddr:
-			return &net.UDPAddr{IP: ip}, nil
-		case *net.IPAddr:
-			return &net.IPAddr{IP: ip}, nil
-		default:
-			return nil, errors.New("neither UDPAddr nor IPAddr")
-		}
-	}
-	for _, ip := range ips {
-		if ip.To4() != nil {
-			return netaddr(ip)
-		}
-	}
-	return nil, errors.New("no A or AAAA record")
-}
+// minInterval is the shortest interval between requests allowed without -f.
+const minInterval = 10 * time.Millisecond
 
-type Ping struct {
-	network, address string
-	protocol         int
-	mtype            icmp.Type
-}
+// minPrivilegedInterval is the shortest interval between requests allowed
+// without both -f and the privileges to open a raw socket.
+const minPrivilegedInterval = time.Millisecond
 
-type Timestamp struct {
-	ID                int
-	Seq               int
-	OriginTimestamp   uint32
-	ReceiveTimestamp  uint32
-	TransmitTimestamp uint32
-}
+// Exit statuses, besides 0 for success. exitDegraded is distinct from
+// exitUsage so that a wrapper checking -max-rtt can tell a degraded path
+// from a mistyped command line.
+const (
+	exitFailure  = 1
+	exitUsage    = 2
+	exitDegraded = 3
+)
 
-const marshalledTimestampLen = 16
+// maxPadding keeps the request within the largest IPv4 datagram.
+const maxPadding = 65535 - 20 - 8 - 16
 
-func (t *Timestamp) Len(proto int) int {
-	if t == nil {
-		return 0
-	}
-	return marshalledTimestampLen
+func help() {
+	fmt.Fprintf(os.Stderr, `NAME
+  %s - measure clock difference between hosts
+USAGE
+  sudo %s [options] <destination>...`, os.Args[0], os.Args[0])
+	fmt.Println()
+	flag.PrintDefaults()
 }
 
-func (t *Timestamp) Marshal(_ int) ([]byte, error) {
-	b := make([]byte, marshalledTimestampLen)
-	b[0], b[1] = byte(t.ID>>8), byte(t.ID)
-	b[2], b[3] = byte(t.Seq>>8), byte(t.Seq)
-
-	unparseInt := func(i uint32) (byte, byte, byte, byte) {
-		return byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i)
-	}
-	b[4], b[5], b[6], b[7] = unparseInt(t.OriginTimestamp)
-	b[8], b[9], b[10], b[11] = unparseInt(t.ReceiveTimestamp)
-	b[12], b[13], b[14], b[15] = unparseInt(t.TransmitTimestamp)
-	return b, nil
+func usageError(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	help()
+	os.Exit(exitUsage)
 }
 
-func ParseTimestamp(b []byte) (*Timestamp, error) {
-	bodyLen := len(b)
-	if bodyLen != marshalledTimestampLen {
-		return nil, fmt.Errorf("timestamp body length %d not equal to 16", bodyLen)
-	}
-	p := &Timestamp{ID: int(b[0])<<8 | int(b[1]), Seq: int(b[2])<<8 | int(b[3])}
-
-	parseInt := func(start int) uint32 {
-		return uint32(b[start])<<24 | uint32(b[start+1])<<16 | uint32(b[start+2])<<8 | uint32(b[start+3])
+// checkHosts sends a single timestamp request to every target and reports
+// whether a reply was received. It returns the exit status.
+func checkHosts(ctx context.Context, ts []target, opts clockdiff.Options) int {
+	status := 0
+	for _, t := range ts {
+		r, err := clockdiff.ClockDiffContext(ctx, t.addr, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", t.label(), err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: timestamp reply received from %s\n", t.name, r.IP)
 	}
-	p.OriginTimestamp = parseInt(4)
-	p.ReceiveTimestamp = parseInt(8)
-	p.TransmitTimestamp = parseInt(12)
-	return p, nil
+	return status
 }
 
-func doPing(host string, tt *Ping, seq int) error {
-	c, err := icmp.ListenPacket(tt.network, tt.address)
-	if err != nil {
-		return err
-	}
-	defer c.Close()
-
-	dst, err := getAddr(host, c, tt.protocol)
-	if err != nil {
-		return err
+// readHosts reads one host per line from r, skipping blank lines and lines
+// starting with #.
+func readHosts(r io.Reader) ([]string, error) {
+	var hosts []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
 	}
+	return hosts, s.Err()
+}
 
-	now := time.Now()
-	today := now.Truncate(24*time.Hour).UnixNano() / 1000000
-	transmitTime := uint32(now.UnixNano()/1000000 - today)
-	wm := icmp.Message{
-		Type: tt.mtype,
-		Code: 0,
-		Body: &Timestamp{
-			ID: os.Getpid() & 0xffff, Seq: 1 << uint(seq),
-			OriginTimestamp: transmitTime,
-		},
+func readHostsFile(name string) ([]string, error) {
+	if name == "-" {
+		return readHosts(os.Stdin)
 	}
-
-	wb, err := wm.Marshal(nil)
+	f, err := os.Open(name)
 	if err != nil {
-		return err
-	}
-	if n, err := c.WriteTo(wb, dst); err != nil {
-		return err
-	} else if n != len(wb) {
-		return fmt.Errorf("got %v; want %v", n, len(wb))
+		return nil, err
 	}
+	defer f.Close()
+	return readHosts(f)
+}
 
-	rb := make([]byte, 1500)
-	if err := c.SetReadDeadline(time.Now().Add(3 * time.Second)); err != nil {
-		return err
+// sourceAddress returns the IPv4 listen address for -I, which is either a
+// literal address or the name of an interface.
+func sourceAddress(s string) (string, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.To4() == nil {
+			return "", fmt.Errorf("source address %s is not an IPv4 address", s)
+		}
+		return ip.String(), nil
 	}
-	n, peer, err := c.ReadFrom(rb)
+	ifi, err := net.InterfaceByName(s)
 	if err != nil {
-		return err
+		return "", err
 	}
-	receivedTime := time.Now().UnixNano()/1000000 - today
-	rm, err := icmp.ParseMessage(tt.protocol, rb[:n])
+	addrs, err := ifi.Addrs()
 	if err != nil {
-		return err
+		return "", err
 	}
-	switch rm.Type {
-	case ipv4.ICMPTypeTimestampReply:
-		b, _ := rm.Body.Marshal(iana.ProtocolICMP)
-		ts, err := ParseTimestamp(b)
-		if err != nil {
-			fmt.Errorf("ParseTimestamp error: %s", err)
-		}
-		remoteReceiveTime := int64(ts.ReceiveTimestamp)
-		rtt := int64(math.Abs(float64(remoteReceiveTime - int64(transmitTime) + receivedTime - int64(ts.TransmitTimestamp))))
-		delta := rtt/2 + int64(transmitTime) - remoteReceiveTime
-		w := new(tabwriter.Writer)
-		w.Init(os.Stdout, 0, 4, 0, '\t', 0)
-		fmt.Fprintf(w, "ICMP timestamp:\tOriginate=%d Receive=%d Transmit=%d\n", ts.OriginTimestamp, ts.ReceiveTimestamp, ts.TransmitTimestamp)
-		fmt.Fprintf(w, "ICMP timestamp RTT:\ttsrtt=%d\n", rtt)
-		fmt.Fprintf(w, "Time difference:\tdelta=%d\n", delta)
-		w.Flush()
-		return nil
-	default:
-		return fmt.Errorf("got %+v from %v; want echo reply", rm, peer)
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), nil
+		}
 	}
+	return "", fmt.Errorf("interface %s has no IPv4 address", s)
 }
 
-func help() {
-	fmt.Fprintf(os.Stderr, `NAME
-  %s - measure clock difference between hosts
-USAGE
-  sudo %s <destination>`, os.Args[0], os.Args[0])
-	fmt.Println()
-	flag.PrintDefaults()
+// reportStatus returns a function recording the reports being filled in,
+// and prints their statistics so far, with their drift if drift is set, to
+// stderr every time one of statusSignals is received, until ctx is done. It
+// returns nil if the platform has no such signal.
+func reportStatus(ctx context.Context, drift bool) func(*report) {
+	if len(statusSignals) == 0 {
+		return nil
+	}
+	var mu sync.Mutex
+	var reports []*report
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, statusSignals...)
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sig:
+			}
+			mu.Lock()
+			reps := append([]*report(nil), reports...)
+			mu.Unlock()
+			for _, rep := range reps {
+				fmt.Fprintf(os.Stderr, "--- %s interim statistics ---\n", rep.host)
+				snap := rep.snapshot()
+				printSummary(os.Stderr, snap, false)
+				if drift {
+					printDrift(os.Stderr, []*report{snap})
+				}
+			}
+		}
+	}()
+	return func(rep *report) {
+		mu.Lock()
+		reports = append(reports, rep)
+		mu.Unlock()
+	}
 }
 
 func main() {
 	flag.Usage = help
+	inet := flag.Bool("4", false, "use IPv4 only")
+	inet6 := flag.Bool("6", false, "use IPv6 only (always fails: ICMPv6 has no timestamp message)")
+	family := flag.String("family", "", "address family of the destination: any, inet or inet6 (like -4 and -6)")
+	count := flag.Int("c", 1, "number of timestamp requests to send, 0 means until interrupted")
+	bestOf := flag.Int("best-of", 0, "send this many requests to every destination and report only the reply with the lowest RTT")
+	interval := flag.Duration("i", time.Second, "time to wait between timestamp requests")
+	flood := flag.Bool("f", false, "flood mode: send the next request as soon as a reply arrives, printing a dot per request and a backspace per reply, until interrupted unless -c is given (requires root)")
+	pps := flag.Float64("pps", 0, "send at most this many requests per second to all destinations together, e.g. with -f; 0 means no limit")
+	jsonOutput := flag.Bool("json", false, "print one JSON object per probe")
+	csvOutput := flag.Bool("csv", false, "print one CSV row per probe")
+	numeric := flag.Bool("n", false, "numeric output only, do not look up the host name of the reply")
+	quiet := flag.Bool("q", false, "print only the delta of every probe")
+	allFamilies := flag.Bool("probe-all-families", false, "probe the destinations over IPv4 and tell which also have IPv6 addresses, which cannot be probed")
+	allAddresses := flag.Bool("all-addresses", false, "probe every IPv4 address of the destinations instead of only the first one")
+	sortSkew := flag.Bool("sort", false, "with several destinations, print the most skewed ones first and those that did not reply last")
+	noColor := flag.Bool("no-color", false, "do not color the deltas, which is otherwise done when printing to a terminal")
+	epoch := flag.Bool("epoch", false, "print the timestamps as milliseconds since the Unix epoch instead of since midnight UTC")
+	table := flag.Bool("table", false, "print a table of every probe and its statistics at the end instead of printing each probe as it completes")
+	tmpl := flag.String("format", "", "print every probe with this text/template, e.g. '{{.Host}} {{.IP}} {{.RTT}} {{.Delta}}', or \"default\" for the main lines of the text output")
+	verbose := flag.Bool("v", false, "log every step of the measurement to stderr")
+	outputPath := flag.String("o", "", "write the results to this file instead of stdout, truncating it unless -append is set")
+	appendOutput := flag.Bool("append", false, "append to the -o file instead of truncating it")
+	hostsFile := flag.String("file", "", "read destinations, one per line, from this file (- for stdin)")
+	deadline := flag.Duration("deadline", 0, "stop the whole run after this long, keeping the results gathered so far; without -c probe until then")
+	timeout := flag.Duration("W", 3*time.Second, "time to wait for each reply")
+	dnsTimeout := flag.Duration("dns-timeout", 0, "time to wait for the lookup of each destination (default no limit beyond the resolver's own)")
+	dnsServer := flag.String("dns-server", "", "look up the destinations with this DNS server, host[:port], instead of the system resolver")
+	retries := flag.Int("r", 0, "number of times to resend a request that timed out or was only partly sent")
+	ttl := flag.Int("t", 0, "IP time-to-live of the requests, 1-255 (default the system default)")
+	tos := flag.Int("Q", 0, "IP type-of-service (DSCP and ECN) byte of the requests, 0-255")
+	size := flag.Int("s", 0, "number of padding bytes appended to the requests")
+	id := flag.Int("id", 0, "ICMP identifier of the requests, 1-65535 (default the process ID); only with a single destination")
+	iface := flag.String("I", "", "source interface or IPv4 address")
+	bind := flag.String("bind", "", "local IPv4 address to listen on (default 0.0.0.0)")
+	unprivileged := flag.Bool("u", false, "use an unprivileged datagram ICMP socket instead of a raw socket; only works where these sockets send timestamp requests, e.g. macOS, not Linux")
+	bindPort := flag.Int("bind-port", 0, "local port to bind in -u mode")
+	origin := flag.Int64("origin", -1, "diagnostic mode: send this originate timestamp, in milliseconds since midnight UTC, and print the raw timestamps of the replies instead of the delta")
+	drift := flag.Bool("drift", false, "at the end, and on SIGUSR1 or SIGINFO, estimate the drift rate of the remote clock from the trend of the deltas")
+	warmup := flag.Int("warmup", 0, "number of requests sent and discarded before the measured ones")
+	maxRTT := flag.Duration("max-rtt", 0, "count probes with a higher RTT as degraded and exit with status 3")
+	parallel := flag.Int("parallel", clockdiff.DefaultParallelism, "number of destinations probed at the same time")
+	recordRoute := flag.Bool("R", false, "record the route of the requests and print it (requires a raw socket)")
+	header := flag.Bool("H", false, "print the IPv4 header of every reply (requires a raw socket)")
+	waitAll := flag.Bool("wait-all", false, "keep reading until the timeout to report duplicate replies")
+	check := flag.Bool("check", false, "only check that a timestamp reply is received, without printing the delta")
+	metricsAddr := flag.String("metrics", "", "serve Prometheus metrics on this address instead of printing results")
+	metricsInterval := flag.Duration("metrics-interval", 30*time.Second, "probe interval in -metrics mode")
+	useSyslog := flag.Bool("syslog", false, "also log every result to syslog, or to stderr if syslog is unavailable")
+	facility := flag.String("syslog-facility", "daemon", "syslog facility used by -syslog")
+	compareNTP := flag.String("compare-ntp", "", "also query this NTP server and report the local and remote clock offsets from it")
 	flag.Parse()
-	if len(flag.Args()) != 1 {
-		help()
+	hosts := flag.Args()
+	if *hostsFile != "" {
+		h, err := readHostsFile(*hostsFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		hosts = append(hosts, h...)
+	}
+	if len(hosts) == 0 {
+		usageError("missing destination")
+	}
+	intervalSet, countSet := false, false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "i":
+			intervalSet = true
+		case "c":
+			countSet = true
+		}
+	})
+	if *appendOutput && *outputPath == "" {
+		usageError("-append requires -o")
+	}
+	if *deadline < 0 {
+		usageError("invalid deadline %s: must not be negative", *deadline)
+	}
+	if *bestOf < 0 {
+		usageError("invalid best-of %d: must not be negative", *bestOf)
+	}
+	if *bestOf > 0 {
+		if countSet {
+			usageError("-best-of cannot be used with -c")
+		}
+		*count = *bestOf
+		countSet = true
+	}
+	if *deadline > 0 && !countSet {
+		*count = 0
+	}
+	if *flood {
+		// Flood mode needs a raw socket, and so the privileges checked
+		// below.
+		if *unprivileged {
+			usageError("flood mode requires a raw socket and cannot be used with -u")
+		}
+		if !intervalSet {
+			*interval = 0
+		}
+		// Like ping -f, flood until interrupted unless told otherwise.
+		if !countSet {
+			*count = 0
+		}
+	}
+	if *size < 0 || *size > maxPadding {
+		usageError("invalid size %d: must be between 0 and %d", *size, maxPadding)
+	}
+	if *header && *unprivileged {
+		usageError("-H requires a raw socket and cannot be used with -u")
+	}
+	if *recordRoute && *unprivileged {
+		usageError("-R requires a raw socket and cannot be used with -u")
+	}
+	if *bindPort != 0 && !*unprivileged {
+		usageError("-bind-port requires -u")
+	}
+	if *bindPort < 0 || *bindPort > 0xffff {
+		usageError("invalid port %d: must be between 1 and 65535", *bindPort)
+	}
+	if *ttl < 0 || *ttl > 0xff {
+		usageError("invalid TTL %d: must be between 1 and 255", *ttl)
+	}
+	if *tos < 0 || *tos > 0xff {
+		usageError("invalid TOS %d: must be between 0 and 255", *tos)
+	}
+	if *id < 0 || *id > 0xffff {
+		usageError("invalid identifier %d: must be between 1 and 65535", *id)
+	}
+	if *origin < -1 || *origin >= 24*60*60*1000 {
+		usageError("invalid originate timestamp %d: must be between 0 and 86399999", *origin)
+	}
+	if *warmup < 0 {
+		usageError("invalid warmup %d: must not be negative", *warmup)
+	}
+	if *maxRTT < 0 {
+		usageError("invalid max RTT %s: must not be negative", *maxRTT)
+	}
+	if *parallel < 1 {
+		usageError("invalid parallelism %d: must be at least 1", *parallel)
+	}
+	if *count < 0 {
+		usageError("invalid count %d: must not be negative", *count)
+	}
+	if *interval < 0 {
+		usageError("invalid interval %s: must not be negative", *interval)
+	}
+	if *pps < 0 {
+		usageError("invalid rate %g: must not be negative", *pps)
+	}
+	if *count != 1 && *interval < minInterval && !*flood {
+		usageError("interval %s is shorter than %s; use -f to allow it", *interval, minInterval)
+	}
+	opts := clockdiff.Options{Timeout: *timeout, Retries: *retries, TTL: *ttl, TOS: *tos, Padding: *size, ID: *id, WaitAll: *waitAll, Header: *header, RecordRoute: *recordRoute, Parallelism: *parallel}
+	if *dnsTimeout < 0 {
+		usageError("invalid DNS timeout %s: must not be negative", *dnsTimeout)
+	}
+	opts.DNSTimeout = *dnsTimeout
+	if *dnsServer != "" {
+		r, err := newResolver(*dnsServer)
+		if err != nil {
+			usageError("%s", err)
+		}
+		opts.Resolver = r
+	}
+	if *origin >= 0 {
+		opts.FixedOrigin, opts.Origin = true, uint32(*origin)
+	}
+	if *unprivileged {
+		opts.Network = "udp4"
+		opts.Port = *bindPort
+	}
+	if *verbose {
+		opts.Logger = log.New(os.Stderr, "", log.LstdFlags|log.Lmicroseconds)
+	}
+	if *bind != "" {
+		if *iface != "" {
+			usageError("-bind and -I are mutually exclusive")
+		}
+		ip := net.ParseIP(*bind)
+		if ip == nil || ip.To4() == nil {
+			usageError("invalid bind address %q: must be an IPv4 address", *bind)
+		}
+		opts.Address = ip.String()
+	}
+	if *iface != "" {
+		addr, err := sourceAddress(*iface)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts.Address = addr
+	}
+	switch {
+	case *inet && *inet6:
+		usageError("-4 and -6 are mutually exclusive")
+	case *inet:
+		opts.Family = clockdiff.FamilyInet
+	case *inet6:
+		opts.Family = clockdiff.FamilyInet6
+	}
+	if *family != "" {
+		f, err := clockdiff.ParseFamily(*family)
+		if err != nil {
+			usageError("%s", err)
+		}
+		if (*inet || *inet6) && f != opts.Family {
+			usageError("-family %s conflicts with -4 or -6", *family)
+		}
+		opts.Family = f
+	}
+	if *allFamilies {
+		if opts.Family == clockdiff.FamilyInet6 {
+			usageError("-probe-all-families cannot be used with -6 or -family inet6")
+		}
+		opts.Family = clockdiff.FamilyInet
+	}
+	if *id != 0 && len(hosts) > 1 {
+		usageError("-id cannot be used with more than one destination: each one is probed with its own identifier")
+	}
+	if *metricsAddr != "" {
+		if *metricsInterval < minInterval {
+			usageError("invalid metrics interval %s: must be at least %s", *metricsInterval, minInterval)
+		}
+		if *allAddresses {
+			usageError("-all-addresses cannot be used with -metrics")
+		}
+	}
+	// Check the privileges only once every flag is known to be valid, so
+	// that a bad flag is reported as such even without them.
+	if *count != 1 && *interval < minPrivilegedInterval {
+		if err := clockdiff.CheckRawSocket(); err != nil {
+			usageError("interval %s is shorter than %s, which requires the privileges to open a raw socket: %s", *interval, minPrivilegedInterval, err)
+		}
+	}
+	if !*unprivileged {
+		if err := clockdiff.CheckRawSocket(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\nuse -u for an unprivileged datagram socket\n", os.Args[0], err)
+			os.Exit(1)
+		}
+	}
+	if *metricsAddr != "" {
+		if err := serveMetrics(*metricsAddr, *metricsInterval, hosts, opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+	ts := targets(hosts)
+	if *allAddresses {
+		ts = expandAddresses(ctx, hosts, opts)
+		if *id != 0 && len(ts) > 1 {
+			usageError("-id cannot be used with -all-addresses when %s has several addresses: each one is probed with its own identifier", hosts[0])
+		}
+	}
+	if *check {
+		os.Exit(checkHosts(ctx, ts, opts))
+	}
+	var t *template.Template
+	if *tmpl != "" {
+		text := *tmpl
+		if text == "default" {
+			text = defaultTemplate
+		}
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		var err error
+		if t, err = template.New("format").Parse(text); err != nil {
+			usageError("invalid -format template: %s", err)
+		}
+		// Parsing does not check the field names: try the template on
+		// an empty result, so that a bad one fails here and not on
+		// every probe.
+		if err := t.Execute(io.Discard, clockdiff.Result{}); err != nil {
+			usageError("invalid -format template: %s", err)
+		}
+	}
+	format := formatText
+	switch {
+	case t != nil:
+		format = formatTemplate
+	case *jsonOutput:
+		format = formatJSON
+	case *csvOutput:
+		format = formatCSV
+	case *quiet:
+		format = formatQuiet
+	}
+	if *table && format != formatText {
+		usageError("-table cannot be combined with -json, -csv, -q or -format")
+	}
+	if opts.FixedOrigin && format == formatText {
+		if *table {
+			usageError("-table cannot be combined with -origin")
+		}
+		format = formatOrigin
+	}
+	ntpOffset, ntpOK := int64(0), false
+	if *compareNTP != "" {
+		var err error
+		if ntpOffset, err = queryNTP(*compareNTP, *timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: NTP query to %s failed: %s\n", *compareNTP, err)
+		} else {
+			ntpOK = true
+		}
+	}
+	p := &prober{opts: opts, count: *count, interval: *interval, warmup: *warmup, maxRTT: *maxRTT}
+	p.track = reportStatus(ctx, *drift)
+	if *pps > 0 {
+		p.limiter = rate.NewLimiter(rate.Limit(*pps), 1)
+	}
+	if *useSyslog {
+		l, err := newResultLogger(*facility)
+		if err != nil {
+			usageError("%s", err)
+		}
+		p.logger = l
+	}
+	// The results go to stdout or, with -o, to the output file. The
+	// flood mode progress and the errors stay on the terminal.
+	stdout := io.Writer(os.Stdout)
+	var file *outputFile
+	if *outputPath != "" {
+		var err error
+		if file, err = openOutput(*outputPath, *appendOutput); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		stdout = file
+	}
+	color := file == nil && useColor(*noColor)
+	out := newPrinter(stdout, outputOptions{format: format, numeric: *numeric, verbose: *verbose, epoch: *epoch, color: color, appending: file != nil && file.appending, template: t, origin: opts.Origin, resolver: opts.Resolver, dnsTimeout: opts.DNSTimeout})
+	if file != nil {
+		out = &filePrinter{printer: out, f: file}
+	}
+	machine := format != formatText
+	// The flood mode progress goes to stderr when stdout carries the
+	// results of a machine readable format, printed after the run.
+	progress := io.Writer(os.Stdout)
+	if machine {
+		progress = os.Stderr
+	}
+	if *flood {
+		p.onSend = func() { fmt.Fprint(progress, ".") }
+		p.onProbe = func(r clockdiff.Result, err error) {
+			if err == nil {
+				fmt.Fprint(progress, "\b \b")
+			}
+		}
+	} else {
+		// Results are printed as soon as the library reports them,
+		// and errors once the prober has recorded them.
+		p.opts.OnResult = func(r clockdiff.Result) {
+			if !*table && *bestOf == 0 && !r.ClockStepped {
+				out.print(r)
+			}
+		}
+		p.onProbe = func(r clockdiff.Result, err error) {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}
+	var reports []*report
+	if len(ts) == 1 {
+		reports = append(reports, p.probeHost(ctx, ts[0]))
+		if *flood {
+			fmt.Fprintln(progress)
+		}
+	} else {
+		reports = p.probeHosts(ctx, ts)
+		if *sortSkew {
+			sortReports(reports)
+		}
+		for _, rep := range reports {
+			for _, err := range rep.errs {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}
+	if *allFamilies {
+		noteIPv6(ctx, os.Stderr, hosts, opts)
+	}
+	run := summarizeRun(reports)
+	if run.Succeeded > 0 && run.Failed > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d of %d destinations did not reply\n", run.Failed, run.Hosts)
+	}
+	printResults(out, stdout, reports, resultOptions{
+		machine:  machine,
+		multi:    len(ts) > 1,
+		streamed: len(ts) == 1 && !*flood,
+		table:    *table,
+		bestOf:   *bestOf,
+		count:    *count,
+		color:    color,
+	})
+	if *drift {
+		w := stdout
+		if machine {
+			w = os.Stderr
+		}
+		printDrift(w, reports)
+	}
+	if ntpOK {
+		w := stdout
+		if machine {
+			w = os.Stderr
+		}
+		printNTPComparison(w, *compareNTP, ntpOffset, reports)
+	}
+	if format == formatJSON && len(ts) > 1 {
+		out.flush()
+		printJSONSummary(stdout, run)
+	}
+	out.flush()
+	if file != nil {
+		if err := file.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
-	host := flag.Args()[0]
-	if _, ok := nettest.SupportsRawIPSocket(); !ok {
-		help()
+	if run.ExitStatus != 0 {
+		os.Exit(run.ExitStatus)
 	}
-	p := &Ping{"ip4:icmp", "0.0.0.0", iana.ProtocolICMP, ipv4.ICMPTypeTimestamp}
-	doPing(host, p, 0)
 }
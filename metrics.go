@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/higebu/goclockdiff/clockdiff"
+)
+
+// exporter periodically probes hosts and serves the latest measurements in
+// the Prometheus text exposition format.
+type exporter struct {
+	hosts []string
+	opts  clockdiff.Options
+
+	mu       sync.Mutex
+	last     map[string]clockdiff.Result
+	failures map[string]uint64
+}
+
+func newExporter(hosts []string, opts clockdiff.Options) *exporter {
+	e := &exporter{
+		hosts:    hosts,
+		opts:     opts,
+		last:     make(map[string]clockdiff.Result),
+		failures: make(map[string]uint64),
+	}
+	for _, host := range hosts {
+		e.failures[host] = 0
+	}
+	return e
+}
+
+func (e *exporter) probe() {
+	p := &prober{opts: e.opts, count: 1}
+	reports := p.probeHosts(context.Background(), targets(e.hosts))
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, rep := range reports {
+		for _, err := range rep.errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(rep.results) == 0 {
+			delete(e.last, rep.host)
+			e.failures[rep.host]++
+			continue
+		}
+		e.last[rep.host] = rep.results[len(rep.results)-1]
+	}
+}
+
+func (e *exporter) run(interval time.Duration) {
+	for {
+		e.probe()
+		time.Sleep(interval)
+	}
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	hosts := make([]string, 0, len(e.last))
+	for host := range e.last {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP clockdiff_delta_milliseconds Clock difference to the host.")
+	fmt.Fprintln(w, "# TYPE clockdiff_delta_milliseconds gauge")
+	for _, host := range hosts {
+		fmt.Fprintf(w, "clockdiff_delta_milliseconds{host=\"%s\"} %d\n", labelValue(host), e.last[host].Delta)
+	}
+	fmt.Fprintln(w, "# HELP clockdiff_rtt_milliseconds ICMP timestamp round-trip time to the host.")
+	fmt.Fprintln(w, "# TYPE clockdiff_rtt_milliseconds gauge")
+	for _, host := range hosts {
+		fmt.Fprintf(w, "clockdiff_rtt_milliseconds{host=\"%s\"} %d\n", labelValue(host), e.last[host].RTT)
+	}
+	fmt.Fprintln(w, "# HELP clockdiff_probe_failures_total Number of failed probes to the host.")
+	fmt.Fprintln(w, "# TYPE clockdiff_probe_failures_total counter")
+	for _, host := range e.hosts {
+		fmt.Fprintf(w, "clockdiff_probe_failures_total{host=\"%s\"} %d\n", labelValue(host), e.failures[host])
+	}
+}
+
+// labelValue escapes s for a label value of the text exposition format,
+// which only escapes backslashes, double quotes and line feeds, unlike %q.
+func labelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// serveMetrics probes hosts every interval and serves the results on addr
+// until the HTTP server fails.
+func serveMetrics(addr string, interval time.Duration, hosts []string, opts clockdiff.Options) error {
+	e := newExporter(hosts, opts)
+	go e.run(interval)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/higebu/goclockdiff/clockdiff"
+)
+
+func TestExporterServeHTTP(t *testing.T) {
+	e := newExporter([]string{"b.example.com", `odd"host\`, "down"}, clockdiff.Options{})
+	e.last["b.example.com"] = clockdiff.Result{RTT: 2, Delta: -15}
+	e.last[`odd"host\`] = clockdiff.Result{RTT: 1, Delta: 3}
+	e.failures["b.example.com"] = 1
+	e.failures["down"] = 4
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	want := `# HELP clockdiff_delta_milliseconds Clock difference to the host.
+# TYPE clockdiff_delta_milliseconds gauge
+clockdiff_delta_milliseconds{host="b.example.com"} -15
+clockdiff_delta_milliseconds{host="odd\"host\\"} 3
+# HELP clockdiff_rtt_milliseconds ICMP timestamp round-trip time to the host.
+# TYPE clockdiff_rtt_milliseconds gauge
+clockdiff_rtt_milliseconds{host="b.example.com"} 2
+clockdiff_rtt_milliseconds{host="odd\"host\\"} 1
+# HELP clockdiff_probe_failures_total Number of failed probes to the host.
+# TYPE clockdiff_probe_failures_total counter
+clockdiff_probe_failures_total{host="b.example.com"} 1
+clockdiff_probe_failures_total{host="odd\"host\\"} 0
+clockdiff_probe_failures_total{host="down"} 4
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"example.com", "example.com"},
+		{`a"b`, `a\"b`},
+		{`a\b`, `a\\b`},
+		{"a\nb", `a\nb`},
+		// Unlike %q, tabs and non-ASCII are kept as they are.
+		{"a\tb", "a\tb"},
+		{"bücher.example", "bücher.example"},
+	}
+	for _, tt := range tests {
+		if got := labelValue(tt.in); got != tt.want {
+			t.Errorf("labelValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/higebu/goclockdiff/clockdiff"
+)
+
+// ntpEpochOffset is the number of seconds from the NTP epoch (1900) to the
+// Unix epoch (1970).
+const ntpEpochOffset = 2208988800
+
+func ntpTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+func fromNTPTime(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	nsec := (v & 0xffffffff) * 1e9 >> 32
+	return time.Unix(secs, int64(nsec))
+}
+
+// queryNTP sends a single SNTP request to server and returns the offset of the
+// local clock in milliseconds, using the sign convention of Result.Delta: local
+// minus reference.
+func queryNTP(server string, timeout time.Duration) (int64, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	c, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, err
+	}
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3 // version 4, client mode
+	t1 := time.Now()
+	origin := ntpTime(t1)
+	binary.BigEndian.PutUint64(req[40:], origin)
+	if _, err := c.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := c.Read(resp)
+	t4 := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	t2, t3, err := parseNTPReply(resp[:n], origin)
+	if err != nil {
+		return 0, err
+	}
+	return ntpOffset(t1, t2, t3, t4), nil
+}
+
+// parseNTPReply checks the SNTP reply resp to the request with the transmit
+// timestamp origin and returns its receive and transmit timestamps.
+func parseNTPReply(resp []byte, origin uint64) (receive, transmit time.Time, err error) {
+	switch {
+	case len(resp) < 48:
+		return time.Time{}, time.Time{}, fmt.Errorf("NTP reply of %d bytes is too short", len(resp))
+	case resp[0]&7 != 4:
+		return time.Time{}, time.Time{}, fmt.Errorf("NTP reply has mode %d, not server mode", resp[0]&7)
+	case resp[0]>>6 == 3:
+		// Leap indicator 3 is the alarm condition.
+		return time.Time{}, time.Time{}, errors.New("NTP server is unsynchronized")
+	case resp[1] == 0:
+		// Stratum 0 carries a kiss code in the reference ID.
+		return time.Time{}, time.Time{}, fmt.Errorf("NTP server sent a kiss-o'-death packet, code %q", bytes.TrimRight(resp[12:16], "\x00"))
+	case binary.BigEndian.Uint64(resp[24:]) != origin:
+		return time.Time{}, time.Time{}, errors.New("NTP reply does not match the request")
+	}
+	return fromNTPTime(binary.BigEndian.Uint64(resp[32:])), fromNTPTime(binary.BigEndian.Uint64(resp[40:])), nil
+}
+
+// ntpOffset returns the offset of the local clock in milliseconds, local minus
+// reference, from the times the request was sent (t1), received by the server
+// (t2), answered (t3) and the reply received (t4).
+func ntpOffset(t1, t2, t3, t4 time.Time) int64 {
+	// The reference is ahead of the local clock by the usual NTP offset;
+	// negate it to get local minus reference.
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	return -offset.Milliseconds()
+}
+
+// printNTPComparison prints the local clock offset from the NTP reference and,
+// for every host, the median remote clock offset from it.
+func printNTPComparison(w io.Writer, server string, local int64, reports []*report) {
+	fmt.Fprintf(w, "NTP reference %s: local clock offset %+dms (local minus reference)\n", server, local)
+	for _, rep := range reports {
+		if len(rep.results) == 0 {
+			continue
+		}
+		delta := clockdiff.Summarize(rep.results).Delta.Median
+		// delta is local minus remote, so remote minus reference is the
+		// local offset minus delta.
+		fmt.Fprintf(w, "%s: remote clock offset %+.0fms from reference\n", rep.host, float64(local)-delta)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNTPTimeRoundTrip(t *testing.T) {
+	for _, tt := range []time.Time{
+		time.Unix(0, 0),
+		time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 12, 0, 0, 999999999, time.UTC),
+		time.Date(2035, 12, 31, 23, 59, 59, 500000000, time.UTC),
+	} {
+		v := ntpTime(tt)
+		if secs := int64(v>>32) - ntpEpochOffset; secs != tt.Unix() {
+			t.Errorf("ntpTime(%v) has %d seconds since the Unix epoch, want %d", tt, secs, tt.Unix())
+		}
+		// The fraction has a resolution of 2^-32 s, and the
+		// conversions truncate.
+		if d := tt.Sub(fromNTPTime(v)); d < 0 || d > 2*time.Nanosecond {
+			t.Errorf("fromNTPTime(ntpTime(%v)) is off by %s", tt, d)
+		}
+	}
+	if got := ntpTime(time.Unix(0, 500000000)) & 0xffffffff; got != 1<<31 {
+		t.Errorf("fraction of half a second = %#x, want %#x", got, uint64(1<<31))
+	}
+}
+
+func TestNTPOffsetSign(t *testing.T) {
+	t1 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	delay := 10 * time.Millisecond
+	tests := []struct {
+		name   string
+		ahead  time.Duration
+		offset int64
+	}{
+		{"in sync", 0, 0},
+		// Local minus reference, like Result.Delta.
+		{"reference ahead", 300 * time.Millisecond, -300},
+		{"reference behind", -300 * time.Millisecond, 300},
+	}
+	for _, tt := range tests {
+		t2 := t1.Add(delay + tt.ahead)
+		t3 := t2.Add(time.Millisecond)
+		t4 := t1.Add(2*delay + time.Millisecond)
+		if got := ntpOffset(t1, t2, t3, t4); got != tt.offset {
+			t.Errorf("%s: ntpOffset = %d, want %d", tt.name, got, tt.offset)
+		}
+	}
+}
+
+func TestParseNTPReply(t *testing.T) {
+	const origin = 0x1234567890abcdef
+	reply := func(edit func(b []byte)) []byte {
+		b := make([]byte, 48)
+		b[0] = 4<<3 | 4 // no leap second warning, version 4, server mode
+		b[1] = 2
+		binary.BigEndian.PutUint64(b[24:], origin)
+		binary.BigEndian.PutUint64(b[32:], ntpTime(time.Unix(100, 0)))
+		binary.BigEndian.PutUint64(b[40:], ntpTime(time.Unix(101, 0)))
+		edit(b)
+		return b
+	}
+	tests := []struct {
+		name string
+		resp []byte
+		err  string
+	}{
+		{"ok", reply(func(b []byte) {}), ""},
+		{"leap second warning", reply(func(b []byte) { b[0] |= 1 << 6 }), ""},
+		{"short", reply(func(b []byte) {})[:47], "too short"},
+		{"client mode", reply(func(b []byte) { b[0] = 4<<3 | 3 }), "not server mode"},
+		{"unsynchronized", reply(func(b []byte) { b[0] |= 3 << 6 }), "unsynchronized"},
+		{"kiss-o'-death", reply(func(b []byte) { b[1] = 0; copy(b[12:], "RATE") }), `kiss-o'-death packet, code "RATE"`},
+		{"other request", reply(func(b []byte) { b[31]++ }), "does not match"},
+	}
+	for _, tt := range tests {
+		receive, transmit, err := parseNTPReply(tt.resp, origin)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err == "" && (!receive.Equal(time.Unix(100, 0)) || !transmit.Equal(time.Unix(101, 0))):
+			t.Errorf("%s: timestamps %v and %v, want 100 s and 101 s", tt.name, receive, transmit)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/higebu/goclockdiff/clockdiff"
+)
+
+// outputFile is the file of -o. Writes are buffered until filePrinter
+// flushes them after a result or Close is called.
+type outputFile struct {
+	*bufio.Writer
+	f *os.File
+
+	// appending is set when -append opened a file that is not empty.
+	appending bool
+}
+
+func openOutput(path string, appending bool) (*outputFile, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &outputFile{Writer: bufio.NewWriter(f), f: f, appending: appending && fi.Size() > 0}, nil
+}
+
+// Close flushes the buffered output and closes the file.
+func (o *outputFile) Close() error {
+	err := o.Flush()
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write %s: %w", o.f.Name(), err)
+	}
+	return nil
+}
+
+// filePrinter flushes the -o file after every result its printer prints, so
+// that the file is up to date while a long run is going on. An error is kept
+// by the file and reported by Close.
+type filePrinter struct {
+	printer
+	f *outputFile
+}
+
+func (p *filePrinter) print(r clockdiff.Result) {
+	p.printer.print(r)
+	p.printer.flush()
+	p.f.Flush()
+}
+
+// printer writes the result of every probe in one output format.
+type printer interface {
+	print(r clockdiff.Result)
+	flush()
+}
+
+// Output formats selected by the command line flags.
+const (
+	formatText = iota
+	formatJSON
+	formatCSV
+	formatQuiet
+	formatTemplate
+	formatOrigin
+)
+
+// defaultTemplate is the -format template selected by "default". It prints
+// the main lines of the text output.
+const defaultTemplate = `Host: {{.IP}}
+ICMP timestamp: Originate={{.OriginTimestamp}} Receive={{.ReceiveTimestamp}} Transmit={{.TransmitTimestamp}}
+ICMP timestamp RTT: tsrtt={{.RTT}}
+Time difference: delta={{.Delta}}
+`
+
+// outputOptions selects the output format and its variations.
+type outputOptions struct {
+	format  int
+	numeric bool
+	verbose bool
+	epoch   bool
+
+	// color highlights the deltas of the text output, see colorDelta.
+	color bool
+
+	// appending is set when the output is appended to a file that is
+	// not empty, so that the CSV header is not repeated.
+	appending bool
+
+	// template is the template of formatTemplate.
+	template *template.Template
+
+	// origin is the originate timestamp sent in formatOrigin.
+	origin uint32
+
+	// resolver and dnsTimeout look up the host names of the text
+	// output, like the destinations: nil uses net.DefaultResolver, and
+	// zero reverseLookupTimeout.
+	resolver   *net.Resolver
+	dnsTimeout time.Duration
+}
+
+func newPrinter(w io.Writer, o outputOptions) printer {
+	switch o.format {
+	case formatJSON:
+		return &jsonPrinter{enc: json.NewEncoder(w)}
+	case formatCSV:
+		return &csvPrinter{w: csv.NewWriter(w), epoch: o.epoch, wroteHeader: o.appending}
+	case formatQuiet:
+		return &quietPrinter{w: w}
+	case formatTemplate:
+		return &templatePrinter{w: w, t: o.template}
+	case formatOrigin:
+		return &originPrinter{w: w, sent: o.origin}
+	default:
+		return &textPrinter{w: w, numeric: o.numeric, verbose: o.verbose, epoch: o.epoch, color: o.color, resolver: o.resolver, dnsTimeout: o.dnsTimeout}
+	}
+}
+
+type textPrinter struct {
+	w          io.Writer
+	numeric    bool
+	verbose    bool
+	epoch      bool
+	color      bool
+	resolver   *net.Resolver
+	dnsTimeout time.Duration
+}
+
+// epochTimestamps returns the originate, receive and transmit timestamps of
+// r and the local receive time in milliseconds since the Unix epoch.
+func epochTimestamps(r clockdiff.Result) (origin, receive, transmit, local int64) {
+	return r.OriginTime.UnixMilli(), r.ReceiveTime.UnixMilli(), r.TransmitTime.UnixMilli(), r.ReceivedAt.UnixMilli()
+}
+
+// reverseLookupTimeout bounds the best-effort PTR lookup of the text output
+// when no -dns-timeout is given.
+const reverseLookupTimeout = time.Second
+
+// reverseLookup returns the host name of ip looked up with r, or
+// net.DefaultResolver if it is nil, within timeout, or reverseLookupTimeout
+// if it is zero. It returns "" if the lookup fails.
+func reverseLookup(ip net.IP, r *net.Resolver, timeout time.Duration) string {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	if timeout == 0 {
+		timeout = reverseLookupTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	names, err := r.LookupAddr(ctx, ip.String())
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+func (p *textPrinter) print(r clockdiff.Result) {
+	o := clockdiff.TextOptions{Epoch: p.epoch, Verbose: p.verbose}
+	if !p.numeric {
+		o.Name = reverseLookup(r.IP, p.resolver, p.dnsTimeout)
+	}
+	if p.color {
+		o.Delta = func(text string, delta int64) string {
+			return colorDelta(text, float64(delta), true)
+		}
+	}
+	r.WriteText(p.w, o)
+}
+
+func (p *textPrinter) flush() {}
+
+// quietPrinter prints only the delta of every probe.
+type quietPrinter struct {
+	w io.Writer
+}
+
+func (p *quietPrinter) print(r clockdiff.Result) {
+	fmt.Fprintln(p.w, r.Delta)
+}
+
+func (p *quietPrinter) flush() {}
+
+// templatePrinter executes a text/template against every Result.
+type templatePrinter struct {
+	w io.Writer
+	t *template.Template
+}
+
+func (p *templatePrinter) print(r clockdiff.Result) {
+	if err := p.t.Execute(p.w, r); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func (p *templatePrinter) flush() {}
+
+// originPrinter prints the raw timestamps of the replies to requests sent
+// with a fixed originate timestamp.
+type originPrinter struct {
+	w    io.Writer
+	sent uint32
+}
+
+func (p *originPrinter) print(r clockdiff.Result) {
+	t := r.ReceivedAt.UTC()
+	local := t.Sub(t.Truncate(24 * time.Hour)).Milliseconds()
+	fmt.Fprintf(p.w, "%s: originate sent=%d echoed=%d receive=%d transmit=%d local receive=%d", r.IP, p.sent, r.OriginTimestamp, r.ReceiveTimestamp, r.TransmitTimestamp, local)
+	if r.OriginTimestamp != p.sent {
+		fmt.Fprint(p.w, " (originate not echoed)")
+	}
+	fmt.Fprintln(p.w)
+}
+
+func (p *originPrinter) flush() {}
+
+type jsonPrinter struct {
+	enc *json.Encoder
+}
+
+func (p *jsonPrinter) print(r clockdiff.Result) {
+	p.enc.Encode(r)
+}
+
+func (p *jsonPrinter) flush() {}
+
+// csvPrinter prints one row per probe. The timestamp column is the local
+// time the request was sent, Result.SentAt, so that rows can be plotted
+// over time.
+type csvPrinter struct {
+	w           *csv.Writer
+	epoch       bool
+	wroteHeader bool
+}
+
+func (p *csvPrinter) print(r clockdiff.Result) {
+	if !p.wroteHeader {
+		p.w.Write([]string{"timestamp", "host", "ip", "rtt_ms", "delta_ms", "origin", "receive", "transmit", "peer", "measured_rtt_us"})
+		p.wroteHeader = true
+	}
+	origin, receive, transmit := int64(r.OriginTimestamp), int64(r.ReceiveTimestamp), int64(r.TransmitTimestamp)
+	if p.epoch {
+		origin, receive, transmit, _ = epochTimestamps(r)
+	}
+	p.w.Write([]string{
+		r.SentAt.UTC().Format(time.RFC3339Nano),
+		r.Host,
+		r.IP.String(),
+		strconv.FormatInt(r.RTT, 10),
+		strconv.FormatInt(r.Delta, 10),
+		strconv.FormatInt(origin, 10),
+		strconv.FormatInt(receive, 10),
+		strconv.FormatInt(transmit, 10),
+		r.Peer,
+		strconv.FormatInt(r.MeasuredRTT.Microseconds(), 10),
+	})
+	// Flush every row to the output so that long running -i sessions
+	// are logged promptly; with -o, filePrinter then flushes the file.
+	p.w.Flush()
+}
+
+func (p *csvPrinter) flush() {
+	p.w.Flush()
+}
+
+// runSummary sums up the outcome of a run over several destinations, for
+// wrappers that only need a single decision.
+type runSummary struct {
+	Hosts     int `json:"hosts"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+
+	// Degraded is the number of destinations with a probe whose RTT
+	// exceeded -max-rtt.
+	Degraded int `json:"degraded"`
+
+	// WorstHost and WorstDelta are the destination with the largest
+	// absolute median delta and that delta, in milliseconds. They are
+	// omitted when no destination replied.
+	WorstHost  string   `json:"worst_host,omitempty"`
+	WorstDelta *float64 `json:"worst_delta_ms,omitempty"`
+
+	ExitStatus int `json:"exit_status"`
+}
+
+func summarizeRun(reports []*report) runSummary {
+	s := runSummary{Hosts: len(reports)}
+	for _, rep := range reports {
+		if rep.degraded > 0 {
+			s.Degraded++
+		}
+		if len(rep.results) == 0 {
+			s.Failed++
+			continue
+		}
+		s.Succeeded++
+		d := clockdiff.Summarize(rep.results).Delta.Median
+		if s.WorstDelta == nil || math.Abs(d) > math.Abs(*s.WorstDelta) {
+			s.WorstHost, s.WorstDelta = rep.host, &d
+		}
+	}
+	switch {
+	case s.Succeeded == 0:
+		s.ExitStatus = exitFailure
+	case s.Degraded > 0:
+		s.ExitStatus = exitDegraded
+	}
+	return s
+}
+
+// printJSONSummary prints s as the last line of the -json output, wrapped in
+// a "summary" object to tell it from the results.
+func printJSONSummary(w io.Writer, s runSummary) {
+	json.NewEncoder(w).Encode(struct {
+		Summary runSummary `json:"summary"`
+	}{s})
+}
+
+// resultOptions selects what printResults prints once every host has been
+// probed.
+type resultOptions struct {
+	// machine is set for every format but the text one, including the
+	// -origin diagnostic output. These print only the results, without
+	// summary.
+	machine bool
+
+	// multi is set when several hosts were probed.
+	multi bool
+
+	// streamed is set when the results were already printed as they
+	// came in, which is done for a single host outside of flood mode.
+	streamed bool
+
+	table  bool
+	bestOf int
+	count  int
+	color  bool
+}
+
+// printResults prints the results of reports through out, or their
+// statistics to w, as selected by o.
+func printResults(out printer, w io.Writer, reports []*report, o resultOptions) {
+	switch {
+	case o.bestOf > 0 && !o.table:
+		for _, rep := range reports {
+			if r, ok := clockdiff.Best(rep.results); ok {
+				out.print(r)
+			}
+		}
+	case o.machine && !o.streamed:
+		for _, rep := range reports {
+			for _, r := range rep.results {
+				out.print(r)
+			}
+		}
+	case o.machine:
+	case o.table:
+		for _, rep := range reports {
+			printProbeTable(w, rep)
+		}
+	case o.multi:
+		printTable(w, reports, o.color)
+	case o.count != 1 || !o.streamed:
+		printSummary(w, reports[0], o.color)
+	}
+}
+
+func printSummary(w io.Writer, rep *report, color bool) {
+	st := clockdiff.Summarize(rep.results)
+	sent := st.Count + len(rep.errs)
+	loss := 0.0
+	if sent > 0 {
+		loss = 100 * float64(len(rep.errs)) / float64(sent)
+	}
+	fmt.Fprintf(w, "%d packets transmitted, %d received, %.1f%% packet loss\n", sent, st.Count, loss)
+	if st.Count == 0 {
+		fmt.Fprintln(w, "no successful probes, no statistics available")
+		return
+	}
+	fmt.Fprintf(w, "rtt min/avg/max/mdev = %.1f/%.1f/%.1f/%.1f ms\n", st.RTT.Min, st.RTT.Avg, st.RTT.Max, st.RTT.Mdev)
+	fmt.Fprintf(w, "delta min/avg/max/mdev = %.1f/%.1f/%.1f/%.1f ms\n", st.Delta.Min, st.Delta.Avg, st.Delta.Max, st.Delta.Mdev)
+	if st.Count < 2 {
+		fmt.Fprintln(w, "rtt jitter = n/a")
+	} else {
+		fmt.Fprintf(w, "rtt jitter = %.1f ms\n", st.Jitter)
+	}
+	fmt.Fprintf(w, "Time difference: %s\n", colorDelta(fmt.Sprintf("median=%.1f", st.Delta.Median), st.Delta.Median, color))
+	if st.Count >= clockdiff.MinConfidenceCount {
+		fmt.Fprintf(w, "Time difference: mean=%.1f ±%.1f ms (95%% confidence, assuming a symmetric path)\n", st.Delta.Avg, st.DeltaCI)
+	}
+}
+
+// printDrift prints the drift of the delta of every report, for -drift.
+func printDrift(w io.Writer, reports []*report) {
+	for _, rep := range reports {
+		ppm, ok := clockdiff.Drift(rep.results)
+		if !ok {
+			fmt.Fprintf(w, "%s: drift: not enough probes\n", rep.host)
+			continue
+		}
+		how := "remote clock runs slower than local"
+		switch {
+		case ppm < 0:
+			how = "remote clock runs faster than local"
+		case ppm == 0:
+			how = "clocks run at the same rate"
+		}
+		fmt.Fprintf(w, "%s: drift: %+.1f ppm (%s)\n", rep.host, ppm, how)
+	}
+}
+
+// printProbeTable prints every result of rep followed by the statistics of
+// their RTT and delta.
+func printProbeTable(out io.Writer, rep *report) {
+	w := new(tabwriter.Writer)
+	w.Init(out, 0, 4, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(out, "%s:\n", rep.host)
+	fmt.Fprintln(w, "SEQ\tRTT\tDELTA\t")
+	for _, r := range rep.results {
+		fmt.Fprintf(w, "%d\t%d\t%d\t\n", r.Seq, r.RTT, r.Delta)
+	}
+	if len(rep.results) > 0 {
+		st := clockdiff.Summarize(rep.results)
+		fmt.Fprintf(w, "min\t%.1f\t%.1f\t\n", st.RTT.Min, st.Delta.Min)
+		fmt.Fprintf(w, "avg\t%.1f\t%.1f\t\n", st.RTT.Avg, st.Delta.Avg)
+		fmt.Fprintf(w, "max\t%.1f\t%.1f\t\n", st.RTT.Max, st.Delta.Max)
+		fmt.Fprintf(w, "mdev\t%.1f\t%.1f\t\n", st.RTT.Mdev, st.Delta.Mdev)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "%d/%d probes answered\n", len(rep.results), len(rep.results)+len(rep.errs))
+}
+
+// printTable prints one line per report. The delta is the last column so
+// that colorDelta does not upset the alignment.
+func printTable(out io.Writer, reports []*report, color bool) {
+	w := new(tabwriter.Writer)
+	w.Init(out, 0, 4, 1, ' ', 0)
+	fmt.Fprintln(w, "HOST\tADDRESS\tPROBES\tRTT\tDELTA")
+	for _, rep := range reports {
+		if len(rep.results) == 0 {
+			fmt.Fprintf(w, "%s\t-\t0/%d\t-\t-", rep.host, len(rep.errs))
+			if len(rep.errs) > 0 {
+				fmt.Fprintf(w, "\t%s", rep.errs[len(rep.errs)-1])
+			}
+			fmt.Fprintln(w)
+			continue
+		}
+		n := len(rep.results) + len(rep.errs)
+		st := clockdiff.Summarize(rep.results)
+		delta := colorDelta(fmt.Sprintf("%.1f", st.Delta.Median), st.Delta.Median, color)
+		fmt.Fprintf(w, "%s\t%s\t%d/%d\t%.1f\t%s\n", rep.host, rep.results[0].IP, len(rep.results), n, st.RTT.Median, delta)
+	}
+	w.Flush()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/higebu/goclockdiff/clockdiff"
+)
+
+func TestReverseLookupResolver(t *testing.T) {
+	var dials int32
+	// A resolver whose server never answers.
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	start := time.Now()
+	if name := reverseLookup(net.IPv4(192, 0, 2, 1), r, 50*time.Millisecond); name != "" {
+		t.Errorf("reverseLookup = %q, want no name", name)
+	}
+	if d := time.Since(start); d >= reverseLookupTimeout/2 {
+		t.Errorf("reverseLookup took %s, want it bounded by its 50ms timeout", d)
+	}
+	if atomic.LoadInt32(&dials) == 0 {
+		t.Error("reverseLookup did not use the given resolver")
+	}
+}
+
+// recordingPrinter is a printer that keeps the results it is given.
+type recordingPrinter struct {
+	results []clockdiff.Result
+}
+
+func (p *recordingPrinter) print(r clockdiff.Result) {
+	p.results = append(p.results, r)
+}
+
+func (p *recordingPrinter) flush() {}
+
+func TestPrintResults(t *testing.T) {
+	rep := &report{host: "192.0.2.1", results: []clockdiff.Result{{RTT: 3, Delta: 5}, {RTT: 1, Delta: 7}}}
+	tests := []struct {
+		name    string
+		o       resultOptions
+		printed int
+		summary bool
+	}{
+		{"streamed text", resultOptions{streamed: true, count: 1}, 0, false},
+		{"streamed text with a count", resultOptions{streamed: true, count: 2}, 0, true},
+		{"streamed json", resultOptions{machine: true, streamed: true, count: 2}, 0, false},
+		{"flood text", resultOptions{count: 2}, 0, true},
+		{"flood text with a single probe", resultOptions{count: 1}, 0, true},
+		{"flood json", resultOptions{machine: true, count: 2}, 2, false},
+		{"flood json with a single probe", resultOptions{machine: true, count: 1}, 2, false},
+		{"best of", resultOptions{machine: true, streamed: true, bestOf: 2, count: 2}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out recordingPrinter
+			var w strings.Builder
+			printResults(&out, &w, []*report{rep}, tt.o)
+			if len(out.results) != tt.printed {
+				t.Errorf("%d results printed, want %d", len(out.results), tt.printed)
+			}
+			if got := strings.Contains(w.String(), "packets transmitted"); got != tt.summary {
+				t.Errorf("summary printed: %v, want %v:\n%s", got, tt.summary, w.String())
+			}
+		})
+	}
+}
+
+// replied returns a report of host whose results have the given deltas.
+func replied(host string, deltas ...int64) *report {
+	rep := &report{host: host}
+	for i, d := range deltas {
+		rep.results = append(rep.results, clockdiff.Result{Host: host, IP: net.IPv4(192, 0, 2, 1), Seq: i, RTT: 1, Delta: d})
+	}
+	return rep
+}
+
+// failed returns a report of host whose probes all failed with err.
+func failed(host string, err error, probes int) *report {
+	rep := &report{host: host}
+	for i := 0; i < probes; i++ {
+		rep.errs = append(rep.errs, err)
+	}
+	return rep
+}
+
+func TestSummarizeRun(t *testing.T) {
+	degraded := replied("slow", 1)
+	degraded.degraded = 1
+	tests := []struct {
+		name       string
+		reports    []*report
+		want       runSummary
+		worstDelta float64
+	}{
+		{
+			"all replied",
+			[]*report{replied("a", 5, 7), replied("b", -20), replied("c", 10)},
+			runSummary{Hosts: 3, Succeeded: 3, WorstHost: "b", ExitStatus: 0},
+			-20,
+		},
+		{
+			"some failed",
+			[]*report{failed("down", clockdiff.ErrTimeout, 2), replied("a", 3)},
+			runSummary{Hosts: 2, Succeeded: 1, Failed: 1, WorstHost: "a", ExitStatus: 0},
+			3,
+		},
+		{
+			"degraded",
+			[]*report{replied("a", 30), degraded},
+			runSummary{Hosts: 2, Succeeded: 2, Degraded: 1, WorstHost: "a", ExitStatus: exitDegraded},
+			30,
+		},
+		{
+			"none replied",
+			[]*report{failed("a", clockdiff.ErrTimeout, 1), failed("b", clockdiff.ErrTimeout, 1)},
+			runSummary{Hosts: 2, Failed: 2, ExitStatus: exitFailure},
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := summarizeRun(tt.reports)
+			d := got.WorstDelta
+			got.WorstDelta = nil
+			if got != tt.want {
+				t.Errorf("summarizeRun = %+v, want %+v", got, tt.want)
+			}
+			switch {
+			case tt.want.WorstHost == "" && d != nil:
+				t.Errorf("worst delta = %v, want none", *d)
+			case tt.want.WorstHost != "" && (d == nil || *d != tt.worstDelta):
+				t.Errorf("worst delta = %v, want %v", d, tt.worstDelta)
+			}
+		})
+	}
+}
+
+func TestPrintJSONSummaryOmitsWorst(t *testing.T) {
+	var w strings.Builder
+	printJSONSummary(&w, summarizeRun([]*report{failed("a", clockdiff.ErrTimeout, 1)}))
+	if s := w.String(); strings.Contains(s, "worst_") {
+		t.Errorf("summary of a run without replies has a worst host: %s", s)
+	}
+	w.Reset()
+	printJSONSummary(&w, summarizeRun([]*report{replied("a", 0)}))
+	if s := w.String(); !strings.Contains(s, `"worst_host":"a"`) || !strings.Contains(s, `"worst_delta_ms":0`) {
+		t.Errorf("summary lacks the worst host with a zero delta: %s", s)
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	lossy := &report{host: "a", errs: []error{clockdiff.ErrTimeout}, results: []clockdiff.Result{
+		{RTT: 2, Delta: 5},
+		{RTT: 4, Delta: 7},
+	}}
+	tests := []struct {
+		name string
+		rep  *report
+		want string
+	}{
+		{
+			"all failed",
+			failed("a", clockdiff.ErrTimeout, 2),
+			"2 packets transmitted, 0 received, 100.0% packet loss\n" +
+				"no successful probes, no statistics available\n",
+		},
+		{
+			"one result",
+			replied("a", -3),
+			"1 packets transmitted, 1 received, 0.0% packet loss\n" +
+				"rtt min/avg/max/mdev = 1.0/1.0/1.0/0.0 ms\n" +
+				"delta min/avg/max/mdev = -3.0/-3.0/-3.0/0.0 ms\n" +
+				"rtt jitter = n/a\n" +
+				"Time difference: median=-3.0\n",
+		},
+		{
+			"some lost",
+			lossy,
+			"3 packets transmitted, 2 received, 33.3% packet loss\n" +
+				"rtt min/avg/max/mdev = 2.0/3.0/4.0/1.0 ms\n" +
+				"delta min/avg/max/mdev = 5.0/6.0/7.0/1.0 ms\n" +
+				"rtt jitter = 2.0 ms\n" +
+				"Time difference: median=6.0\n",
+		},
+		{
+			// The sample standard deviation is 2, and the t value
+			// for 2 degrees of freedom 4.303: 4.303*2/√3 = 5.0.
+			"confidence",
+			replied("a", 4, 6, 8),
+			"3 packets transmitted, 3 received, 0.0% packet loss\n" +
+				"rtt min/avg/max/mdev = 1.0/1.0/1.0/0.0 ms\n" +
+				"delta min/avg/max/mdev = 4.0/6.0/8.0/1.6 ms\n" +
+				"rtt jitter = 0.0 ms\n" +
+				"Time difference: median=6.0\n" +
+				"Time difference: mean=6.0 ±5.0 ms (95% confidence, assuming a symmetric path)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w strings.Builder
+			printSummary(&w, tt.rep, false)
+			if got := w.String(); got != tt.want {
+				t.Errorf("printSummary printed\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintTable(t *testing.T) {
+	reports := []*report{
+		replied("ahead.example.com", 5, 7),
+		failed("down", clockdiff.ErrTimeout, 2),
+		{host: "never"},
+	}
+	// The delta is the last cell of a line, so the error that follows it
+	// on a failed line is not aligned with anything.
+	want := "HOST              ADDRESS   PROBES RTT DELTA\n" +
+		"ahead.example.com 192.0.2.1 2/2    1.0 6.0\n" +
+		"down              -         0/2    -   - " + clockdiff.ErrTimeout.Error() + "\n" +
+		"never             -         0/0    -   -\n"
+	var w strings.Builder
+	printTable(&w, reports, false)
+	if got := w.String(); got != want {
+		t.Errorf("printTable printed\n%s\nwant\n%s", got, want)
+	}
+}
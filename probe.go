@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/higebu/goclockdiff/clockdiff"
+	"golang.org/x/time/rate"
+)
+
+// asymmetryThreshold is the ratio between the one-way delays above which the
+// path is considered too asymmetric for the delta to be reliable.
+const asymmetryThreshold = 4
+
+func asymmetric(r clockdiff.Result) bool {
+	f, b := float64(r.ForwardDelay), float64(r.ReturnDelay)
+	if f <= 0 || b <= 0 {
+		return false
+	}
+	return f/b > asymmetryThreshold || b/f > asymmetryThreshold
+}
+
+// timezoneTolerance is how close to a whole number of hours the delta has to
+// be for timezoneHours to blame it on a clock kept in local time.
+const timezoneTolerance = time.Minute
+
+// timezoneHours returns the number of hours the delta of r is off if the
+// remote host apparently counts milliseconds since its local midnight rather
+// than midnight UTC, or 0.
+func timezoneHours(r clockdiff.Result) int {
+	ms := time.Duration(r.Delta) * time.Millisecond
+	hours := ms.Round(time.Hour)
+	if hours == 0 {
+		return 0
+	}
+	if d := ms - hours; d > timezoneTolerance || d < -timezoneTolerance {
+		return 0
+	}
+	return int(hours / time.Hour)
+}
+
+// peerIP returns the IP address of Result.Peer, which carries a port for
+// datagram sockets.
+func peerIP(peer string) net.IP {
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	return net.ParseIP(peer)
+}
+
+// target is a destination to probe. addr is what is probed and name, the
+// host it was given as, what the results are reported under. They differ
+// for the addresses of -all-addresses.
+type target struct {
+	name, addr string
+}
+
+// label returns how t is referred to in messages: its name, followed by the
+// probed address if it differs.
+func (t target) label() string {
+	if t.addr == t.name {
+		return t.name
+	}
+	return fmt.Sprintf("%s (%s)", t.name, t.addr)
+}
+
+// targets returns the targets probing every host as given.
+func targets(hosts []string) []target {
+	ts := make([]target, len(hosts))
+	for i, host := range hosts {
+		ts[i] = target{name: host, addr: host}
+	}
+	return ts
+}
+
+// expandAddresses returns the targets probing every IPv4 address of every
+// host name of hosts, so that each one is probed separately, under its name.
+// Hosts that cannot be resolved are kept, for the probes to report the
+// error.
+func expandAddresses(ctx context.Context, hosts []string, opts clockdiff.Options) []target {
+	var ts []target
+	for _, host := range hosts {
+		ips, err := clockdiff.LookupIPv4(ctx, host, opts)
+		if err != nil || net.ParseIP(host) != nil {
+			ts = append(ts, target{name: host, addr: host})
+			continue
+		}
+		for _, ip := range ips {
+			ts = append(ts, target{name: host, addr: ip.String()})
+		}
+	}
+	return ts
+}
+
+// noteIPv6 tells on w, for -probe-all-families, which of hosts also have
+// IPv6 addresses. These cannot be probed: ICMPv6 has no timestamp message.
+// The lookups use the resolver and timeout of opts.
+func noteIPv6(ctx context.Context, w io.Writer, hosts []string, opts clockdiff.Options) {
+	r := opts.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	for _, host := range hosts {
+		var v6 []string
+		if ip := net.ParseIP(host); ip != nil {
+			if ip.To4() == nil {
+				v6 = append(v6, ip.String())
+			}
+		} else {
+			ips, err := lookupIPv6(ctx, r, host, opts.DNSTimeout)
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && (dnsErr.IsTimeout || dnsErr.IsTemporary) {
+				// Unlike a missing record, a failed lookup
+				// leaves the question open.
+				fmt.Fprintf(w, "%s: inet6: %s\n", host, err)
+				continue
+			}
+			for _, ip := range ips {
+				v6 = append(v6, ip.String())
+			}
+		}
+		if len(v6) == 0 {
+			fmt.Fprintf(w, "%s: inet6: no IPv6 address\n", host)
+			continue
+		}
+		fmt.Fprintf(w, "%s: inet6: %s not probed: ICMPv6 has no timestamp message\n", host, strings.Join(v6, ", "))
+	}
+}
+
+func lookupIPv6(ctx context.Context, r *net.Resolver, host string, timeout time.Duration) ([]net.IP, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return r.LookupIP(ctx, "ip6", host)
+}
+
+// newResolver returns a resolver querying the DNS server at addr, a host
+// with an optional port, instead of the ones of the system.
+func newResolver(server string) (*net.Resolver, error) {
+	addr := server
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid DNS server %q", server)
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}, nil
+}
+
+type report struct {
+	host string
+
+	// mu guards results and errs while the host is being probed, for
+	// snapshot.
+	mu      sync.Mutex
+	results []clockdiff.Result
+	errs    []error
+
+	// degraded is the number of results whose RTT exceeded
+	// prober.maxRTT.
+	degraded int
+}
+
+func (rep *report) addResult(r clockdiff.Result) {
+	rep.mu.Lock()
+	rep.results = append(rep.results, r)
+	rep.mu.Unlock()
+}
+
+func (rep *report) addErr(err error) {
+	rep.mu.Lock()
+	rep.errs = append(rep.errs, err)
+	rep.mu.Unlock()
+}
+
+// snapshot returns a copy of the results and errors recorded so far.
+func (rep *report) snapshot() *report {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return &report{
+		host:    rep.host,
+		results: append([]clockdiff.Result(nil), rep.results...),
+		errs:    append([]error(nil), rep.errs...),
+	}
+}
+
+// sortReports orders reports by the absolute median delta of their results,
+// largest first, followed by the hosts that did not reply.
+func sortReports(reports []*report) {
+	skew := make(map[*report]float64, len(reports))
+	for _, rep := range reports {
+		if len(rep.results) > 0 {
+			skew[rep] = math.Abs(clockdiff.Summarize(rep.results).Delta.Median)
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		si, oki := skew[reports[i]]
+		sj, okj := skew[reports[j]]
+		if oki != okj {
+			return oki
+		}
+		return si > sj
+	})
+}
+
+// prober sends count timestamp requests to every host, waiting interval
+// between them. A count of 0 probes until the context is done.
+type prober struct {
+	opts     clockdiff.Options
+	count    int
+	interval time.Duration
+
+	// maxRTT, if not zero, is the RTT above which a result is counted as
+	// degraded.
+	maxRTT time.Duration
+
+	// warmup is the number of requests sent to every host before the
+	// count probes. Their outcome is discarded, so that ARP resolution
+	// and cold route caches do not skew the results.
+	warmup int
+
+	// limiter, if not nil, caps the rate at which requests are sent to
+	// all hosts together, on top of interval. Retries sent by the
+	// library are not counted.
+	limiter *rate.Limiter
+
+	// onSend, if not nil, is called before every request is sent, and
+	// onProbe with the outcome of every probe as soon as it completes.
+	// Neither is used by probeHosts, nor is opts.OnResult.
+	onSend  func()
+	onProbe func(clockdiff.Result, error)
+
+	// logger, if not nil, logs every successful probe, including those
+	// of probeHosts.
+	logger *log.Logger
+
+	// track, if not nil, is called with every report when probing of
+	// its host starts, including by probeHosts.
+	track func(*report)
+}
+
+// probeHost probes the address of t and reports the results under its name,
+// in the report and in Result.Host. Failed probes are recorded and do not
+// stop the remaining ones.
+func (p *prober) probeHost(ctx context.Context, t target) *report {
+	rep := &report{host: t.name}
+	if p.track != nil {
+		p.track(rep)
+	}
+	host := t.label()
+	opts := p.opts
+	if onResult := opts.OnResult; onResult != nil && t.name != t.addr {
+		opts.OnResult = func(r clockdiff.Result) {
+			r.Host = t.name
+			onResult(r)
+		}
+	}
+	for i := 0; p.count == 0 || i < p.warmup+p.count; i++ {
+		if i > 0 && p.interval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(p.interval):
+			}
+		}
+		if p.limiter != nil {
+			if err := p.limiter.Wait(ctx); err != nil {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		// Leave room for the sequence numbers used by retries.
+		opts.Seq = i * (opts.Retries + 1)
+		if i < p.warmup {
+			warmup := opts
+			warmup.OnResult = nil
+			clockdiff.ClockDiffContext(ctx, t.addr, warmup)
+			continue
+		}
+		seq := i - p.warmup
+		if p.onSend != nil {
+			p.onSend()
+		}
+		r, err := clockdiff.ClockDiffContext(ctx, t.addr, opts)
+		r.Host = t.name
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			err = fmt.Errorf("%s: probe %d: %s", host, seq, err)
+			rep.addErr(err)
+		} else if r.ClockStepped {
+			// The delta of this probe is meaningless; count it as lost.
+			err = fmt.Errorf("%s: probe %d: local clock was changed during the probe, discarding it", host, seq)
+			rep.addErr(err)
+		} else {
+			if r.NonStandard {
+				fmt.Fprintf(os.Stderr, "warning: %s replied with non-standard timestamps; delta may be meaningless\n", host)
+			}
+			switch {
+			case !r.ReceiveValid && !r.TransmitValid:
+				fmt.Fprintf(os.Stderr, "warning: %s: reply has neither a receive nor a transmit timestamp; delta is meaningless\n", host)
+			case !r.ReceiveValid:
+				fmt.Fprintf(os.Stderr, "warning: %s: reply has no receive timestamp; using the transmit timestamp\n", host)
+			case !r.TransmitValid:
+				fmt.Fprintf(os.Stderr, "warning: %s: reply has no transmit timestamp; using the receive timestamp\n", host)
+			}
+			if h := timezoneHours(r); h != 0 {
+				fmt.Fprintf(os.Stderr, "warning: %s: possible timezone-based clock: delta may be off by %dh\n", host, h)
+			}
+			if ip := peerIP(r.Peer); ip != nil && !ip.Equal(r.IP) {
+				fmt.Fprintf(os.Stderr, "warning: %s: reply came from %s, not from %s\n", host, ip, r.IP)
+			}
+			if asymmetric(r) {
+				fmt.Fprintf(os.Stderr, "warning: %s: asymmetric path (forward %dms, return %dms); delta is unreliable\n", host, r.ForwardDelay, r.ReturnDelay)
+			}
+			if p.maxRTT > 0 && time.Duration(r.RTT)*time.Millisecond > p.maxRTT {
+				fmt.Fprintf(os.Stderr, "warning: %s: rtt %dms exceeds %s\n", host, r.RTT, p.maxRTT)
+				rep.degraded++
+			}
+			if p.logger != nil {
+				p.logger.Printf("host=%s ip=%s rtt=%dms delta=%dms", t.name, r.IP, r.RTT, r.Delta)
+			}
+			rep.addResult(r)
+		}
+		if p.onProbe != nil {
+			p.onProbe(r, err)
+		}
+	}
+	return rep
+}
+
+// probeHosts probes every target, opts.Parallelism of them at the same time
+// as clockdiff.EachHost does, and returns the reports in the same order as
+// ts.
+func (p *prober) probeHosts(ctx context.Context, ts []target) []*report {
+	q := *p
+	q.onSend, q.onProbe, q.opts.OnResult = nil, nil, nil
+	addrs := make([]string, len(ts))
+	for i, t := range ts {
+		addrs[i] = t.addr
+	}
+	reports := make([]*report, len(ts))
+	clockdiff.EachHost(addrs, q.opts, func(i int, _ string, opts clockdiff.Options) {
+		h := q
+		h.opts = opts
+		reports[i] = h.probeHost(ctx, ts[i])
+	})
+	return reports
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/higebu/goclockdiff/clockdiff"
+)
+
+func TestTargetLabel(t *testing.T) {
+	tests := []struct {
+		t    target
+		want string
+	}{
+		{target{name: "192.0.2.1", addr: "192.0.2.1"}, "192.0.2.1"},
+		{target{name: "pool.example.com", addr: "192.0.2.1"}, "pool.example.com (192.0.2.1)"},
+	}
+	for _, tt := range tests {
+		if got := tt.t.label(); got != tt.want {
+			t.Errorf("%+v.label() = %q, want %q", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestExpandAddressesKeepsLiterals(t *testing.T) {
+	got := expandAddresses(context.Background(), []string{"192.0.2.1", "::1"}, clockdiff.Options{})
+	want := targets([]string{"192.0.2.1", "::1"})
+	if len(got) != len(want) {
+		t.Fatalf("expandAddresses = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("target %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSortReports(t *testing.T) {
+	reports := []*report{
+		failed("down1", clockdiff.ErrTimeout, 1),
+		replied("small", 1, -2, 3),
+		failed("down2", clockdiff.ErrTimeout, 2),
+		replied("behind", -40),
+		replied("ahead", 25, 30, 35),
+		failed("down3", clockdiff.ErrTimeout, 1),
+		replied("zero", 0),
+	}
+	sortReports(reports)
+	want := []string{"behind", "ahead", "small", "zero", "down1", "down2", "down3"}
+	for i, rep := range reports {
+		if rep.host != want[i] {
+			t.Errorf("report %d is %s, want %s", i, rep.host, want[i])
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var statusSignals = []os.Signal{syscall.SIGINFO, syscall.SIGUSR1}
@@ -0,0 +1,9 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package main
+
+import "os"
+
+// statusSignals is empty where there is no signal to request the interim
+// statistics.
+var statusSignals []os.Signal
@@ -0,0 +1,10 @@
+//go:build aix || linux || solaris
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var statusSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+var errUnknownFacility = errors.New("unknown syslog facility")
+
+// newResultLogger returns a logger writing to syslog with the given facility,
+// or to stderr if syslog is unavailable.
+func newResultLogger(facility string) (*log.Logger, error) {
+	l, err := newSyslogLogger(facility)
+	if errors.Is(err, errUnknownFacility) {
+		return nil, err
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: syslog is unavailable: %s; logging results to stderr\n", err)
+		return log.New(os.Stderr, "", log.LstdFlags), nil
+	}
+	return l, nil
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+func newSyslogLogger(facility string) (*log.Logger, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}